@@ -0,0 +1,111 @@
+package main
+
+import (
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	AdjudicationCheckmate   = "checkmate"
+	AdjudicationStalemate   = "stalemate"
+	AdjudicationMaterial    = "insufficient material"
+	AdjudicationRepetition  = "threefold repetition"
+	AdjudicationFiftyMoves  = "fifty moves"
+	AdjudicationWinEval     = "win eval"
+	AdjudicationDrawEval    = "draw eval"
+	adjudicationStatsPrefix = "adjudicated: "
+)
+
+// adjudicate assigns result to main line of unfinished game.
+// Final position rules are checked first, then trailing evals.
+func adjudicate(items []Item, settings *AnalyzeSettings) (gameResult float32, reason string, ok bool) {
+	if len(items) == 0 {
+		return 0, "", false
+	}
+
+	var last = &items[len(items)-1]
+	var final, _, finalOk = makeMoveSAN(&last.Position, last.Castling, last.SanMove)
+	if finalOk {
+		if len(final.GenerateLegalMoves()) == 0 {
+			if !final.IsCheck() {
+				return 0.5, AdjudicationStalemate, true
+			}
+			if final.WhiteMove {
+				return 0, AdjudicationCheckmate, true
+			}
+			return 1, AdjudicationCheckmate, true
+		}
+		if isDraw(&final) {
+			return 0.5, AdjudicationMaterial, true
+		}
+		// positions before the last capture or pawn move (Rule50 is 0) can not repeat
+		var repetitions = 1
+		for i := len(items) - 1; i >= 0; i-- {
+			if items[i].Position.Key == final.Key {
+				repetitions++
+			}
+			if items[i].Position.Rule50 == 0 {
+				break
+			}
+		}
+		if repetitions >= 3 {
+			return 0.5, AdjudicationRepetition, true
+		}
+		if final.Rule50 >= 100 {
+			return 0.5, AdjudicationFiftyMoves, true
+		}
+	}
+
+	if winner, ok := trailingWinner(items, settings.AdjudicateWinScore, 2*settings.AdjudicateWinMoves); ok {
+		return winner, AdjudicationWinEval, true
+	}
+	if trailingDraw(items, settings.AdjudicateDrawScore, 2*settings.AdjudicateDrawMoves) {
+		return 0.5, AdjudicationDrawEval, true
+	}
+	return 0, "", false
+}
+
+// trailingWinner checks that last plies are evaluated at least winScore in favour of the same side.
+func trailingWinner(items []Item, winScore int, plies int) (float32, bool) {
+	if plies == 0 || len(items) < plies {
+		return 0, false
+	}
+	var whiteWins, blackWins = true, true
+	for i := len(items) - plies; i < len(items); i++ {
+		var item = &items[i]
+		if !item.Evaluated {
+			return 0, false
+		}
+		var score = whiteScore(&item.Position, item.Comment.Score)
+		whiteWins = whiteWins && (score.Mate > 0 || score.Mate == 0 && score.Centipawns >= winScore)
+		blackWins = blackWins && (score.Mate < 0 || score.Mate == 0 && score.Centipawns <= -winScore)
+	}
+	if whiteWins {
+		return 1, true
+	}
+	if blackWins {
+		return 0, true
+	}
+	return 0, false
+}
+
+// trailingDraw checks that last plies are evaluated within drawScore.
+func trailingDraw(items []Item, drawScore int, plies int) bool {
+	if plies == 0 || len(items) < plies {
+		return false
+	}
+	for i := len(items) - plies; i < len(items); i++ {
+		var item = &items[i]
+		if !item.Evaluated || item.Comment.Score.Mate != 0 ||
+			item.Comment.Score.Centipawns > drawScore || item.Comment.Score.Centipawns < -drawScore {
+			return false
+		}
+	}
+	return true
+}
+
+func whiteScore(p *common.Position, score common.UciScore) common.UciScore {
+	if p.WhiteMove {
+		return score
+	}
+	return negateScore(score)
+}
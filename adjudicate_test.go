@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAdjudicate(t *testing.T) {
+	var settings = &AnalyzeSettings{
+		Adjudicate:          true,
+		AdjudicateWinScore:  1000,
+		AdjudicateWinMoves:  3,
+		AdjudicateDrawScore: 10,
+		AdjudicateDrawMoves: 2,
+	}
+	for _, test := range []struct {
+		movetext string
+		result   float32
+		reason   string
+	}{
+		{"1. f3 e5 2. g4 Qh4# *", 0, AdjudicationCheckmate},
+		{"1. Nf3 Nf6 2. Ng1 Ng8 3. Nf3 Nf6 4. Ng1 Ng8 *", 0.5, AdjudicationRepetition},
+		{"1. Nf3 Nf6 2. Ng1 Ng8 3. e3 e6 4. Nf3 Nf6 5. Ng1 Ng8 *", 0, ""},
+		{"1. Nf3 Nf6 2. Ng1 Ng8 3. e3 e6 4. Nf3 Nf6 5. Ng1 Ng8 6. Nf3 Nf6 7. Ng1 Ng8 *", 0.5, AdjudicationRepetition},
+		{"1. e4 {+10.00/20} e5 {-10.00/20} 2. Nf3 {+10.50/20} Nc6 {-M7/20} 3. Bb5 {+M5/20} a6 {-10.50/20} *",
+			1, AdjudicationWinEval},
+		{"1. e4 {+10.00/20} e5 {-10.00/20} 2. Nf3 {+10.50/20} Nc6 {+M7/20} 3. Bb5 {+M5/20} a6 {-9.50/20} *",
+			0, ""},
+		{"1. e4 {+0.05/20} e5 {-0.05/20} 2. Nf3 {0.00/20} Nc6 {+0.10/20} *", 0.5, AdjudicationDrawEval},
+		{"1. e4 {+0.05/20} e5 {-0.05/20} 2. Nf3 Nc6 {+0.10/20} *", 0, ""},
+	} {
+		var game, err = ParseGame("[Result \"*\"]\n\n"+test.movetext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result, reason, ok = adjudicate(game.Items, settings)
+		if ok != (test.reason != "") || result != test.result || reason != test.reason {
+			t.Errorf("%v: got %v %v %v", test.movetext, result, reason, ok)
+		}
+	}
+
+	var stats = NewStats()
+	var positions, err = AnalyzeGame(&AllQuietService{}, settings, nil,
+		"[Result \"*\"]\n\n1. e4 {+0.05/20} e5 {-0.05/20} 2. Nf3 {0.00/20} Nc6 {+0.10/20} *", stats)
+	if err != nil || len(positions) != 4 || positions[0].gameResult != 0.5 ||
+		stats.Counts()[adjudicationStatsPrefix+AdjudicationDrawEval] != 1 {
+		t.Errorf("got %v %v %v", positions, err, stats.Counts())
+	}
+	if _, err := AnalyzeGame(&AllQuietService{}, &AnalyzeSettings{}, nil, "[Result \"*\"]\n\n1. f3 e5 2. g4 Qh4# *", nil); err == nil {
+		t.Error("unfinished game analyzed without adjudication")
+	}
+}
@@ -2,21 +2,84 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	MateDrop = "drop"
+	MateKeep = "keep"
 )
 
+const (
+	VariationsOff      = "off"
+	VariationsResult   = "result"
+	VariationsNoResult = "noresult"
+)
+
+type AnalyzeSettings struct {
+	// Variations selects whether positions from side lines are emitted:
+	// off, result (labelled with main line game result) or noresult.
+	Variations string
+	// Mate is drop or keep. Kept mate score is converted to MateScore centipawns
+	// reduced by MateStep per move to mate, but not below MateScore/2.
+	Mate      string
+	MateScore int
+	MateStep  int
+	// Adjudicate assigns result to unfinished (*) game by final position (checkmate, stalemate,
+	// insufficient material, repetition, fifty moves) or by trailing evals:
+	// AdjudicateWinMoves moves of both sides with score at least AdjudicateWinScore for the same side
+	// or AdjudicateDrawMoves moves with score within AdjudicateDrawScore. Zero moves disables eval rule.
+	Adjudicate          bool
+	AdjudicateWinScore  int
+	AdjudicateWinMoves  int
+	AdjudicateDrawScore int
+	AdjudicateDrawMoves int
+	// Terminations are rules "class=action" for games terminated on time, by illegal move,
+	// by engine crash or disconnect: keep (default), drop or adjudicate from final position and last evals.
+	Terminations []string
+	// Select selects games by tags.
+	Select GameSelection
+	// GameFilter and PositionFilter are expressions selecting games and positions, see expr.go.
+	GameFilter     string
+	PositionFilter string
+	gameFilter     *Expr
+	positionFilter *Expr
+	// SkipBook rejects book moves and BookSkipPlies plies after them. Book moves are commented {book},
+	// have zero time depth 1 pseudo evals, lead to positions of BookFile (EPD or FEN list)
+	// or, with BookUntilEval, precede the first engine eval of game.
+	SkipBook      bool
+	BookFile      string
+	BookUntilEval bool
+	BookSkipPlies int
+	book          *OpeningBook
+	Filters       FilterSettings
+	// Sample samples positions of game passed filters.
+	Sample SampleSettings
+	// DatasetSeparator separates columns of dataset input lines, see DatasetSeparatorAuto.
+	DatasetSeparator string
+	// CommentFormats are rules "format" or "glob=format" selecting eval comment parser per input file.
+	CommentFormats []string
+}
+
 func analyzeGames(
 	ctx context.Context,
 	quietService IQuietService,
-	pgns <-chan string,
+	settings *AnalyzeSettings,
+	comments *CommentSelector,
+	stats *Stats,
+	reporter *ErrorReporter,
+	pgns <-chan Pgn,
 	games chan<- []PositionInfo,
 ) error {
 	for pgn := range pgns {
-		var game, err = AnalyzeGame(quietService, pgn)
+		reporter.Game()
+		var game, err = AnalyzeGame(quietService, settings, comments.Parser(pgn.File), pgn.Text, stats)
 		if err != nil {
-			log.Println("AnalyzeGame error", err, pgn)
-			continue
+			// positions before illegal move are kept
+			if err := reporter.Report(&pgn, err); err != nil {
+				return err
+			}
 		}
 		if len(game) != 0 {
 			select {
@@ -29,57 +92,203 @@ func analyzeGames(
 	return nil
 }
 
-func AnalyzeGame(quietService IQuietService, pgn string) ([]PositionInfo, error) {
-	var game, err = ParseGame(pgn)
+// AnalyzeGame returns positions of game, adjudications are counted in stats (may be nil).
+// Game with illegal move in main line is analyzed up to the move, its positions are returned with the error.
+func AnalyzeGame(quietService IQuietService, settings *AnalyzeSettings,
+	commentParser CommentParser, pgn string, stats *Stats) ([]PositionInfo, error) {
+	// select by tags before moves are parsed, tag errors are reported by ParseGame
+	if settings.Select.active {
+		if tags, _, _, err := parseTagSection(pgn); err == nil {
+			if reason := settings.Select.selectTags(tags); reason != "" {
+				stats.Add(selectionStatsPrefix+reason, 1)
+				return nil, nil
+			}
+		}
+	}
+
+	var game, err = ParseGame(pgn, commentParser)
 	if err != nil {
 		return nil, err
 	}
 
-	var sGameResult, gameResultOk = tagValue(game.Tags, "Result")
-	if !gameResultOk {
-		return nil, fmt.Errorf("bad game result")
+	if _, found := game.TagValue("PlyCount"); !found {
+		if reason := settings.Select.selectPlies(len(game.Items)); reason != "" {
+			stats.Add(selectionStatsPrefix+reason, 1)
+			return nil, nil
+		}
+	}
+
+	gameResult, ok, err := resolveGameResult(&game, settings, stats)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	if settings.gameFilter != nil && !settings.gameFilter.eval(&exprEnv{
+		game:        &game,
+		tags:        game.Tags,
+		gameResult:  gameResult,
+		termination: classifyTermination(&game),
+	}) {
+		stats.Add(selectionStatsPrefix+"game filter", 1)
+		return nil, nil
+	}
+
+	var variationResult = gameResult
+	if settings.Variations == VariationsNoResult {
+		variationResult = NoGameResult
+	}
+
+	var la = &lineAnalyzer{
+		filters:         settings.newFilterChain(quietService),
+		settings:        settings,
+		variations:      settings.Variations != VariationsOff,
+		variationResult: variationResult,
+		repeatPositions: make(map[uint64]int),
+		bookPlies:       -1,
+		tags:            game.Tags,
+	}
+	if settings.SkipBook {
+		la.bookPlies = bookPlies(game.Items, settings.book, settings.BookUntilEval)
 	}
+	la.analyzeLine(game.Items, 0, game.StartPly, gameResult)
+	la.filters.Flush(stats)
 
-	var gameResult float32
+	var positions = settings.Sample.sampleGame(la.result, pgn, stats)
+	if game.BadMove != nil {
+		return positions, game.BadMove
+	}
+	return positions, nil
+}
+
+// resolveGameResult returns game result according to termination and adjudication settings.
+// Game dropped by termination rule is not an error.
+func resolveGameResult(game *Game, settings *AnalyzeSettings, stats *Stats) (float32, bool, error) {
+	var termination = classifyTermination(game)
+	switch settings.terminationAction(termination) {
+	case TerminationDrop:
+		stats.Add(terminationStatsPrefix+termination+" dropped", 1)
+		return 0, false, nil
+	case TerminationAdjudicate:
+		var gameResult, reason, ok = adjudicate(game.Items, settings)
+		if !ok {
+			stats.Add(terminationStatsPrefix+termination+" not adjudicated", 1)
+			return 0, false, nil
+		}
+		stats.Add(terminationStatsPrefix+termination+" adjudicated", 1)
+		stats.Add(adjudicationStatsPrefix+reason, 1)
+		return gameResult, true, nil
+	}
+	if termination != TerminationNormal {
+		stats.Add(terminationStatsPrefix+termination+" kept", 1)
+	}
+
+	var sGameResult, gameResultOk = game.TagValue("Result")
+	if !gameResultOk {
+		return 0, false, newGameError(ReasonBadResult, 0, "no Result tag")
+	}
 	switch sGameResult {
 	case GameResultWhiteWin:
-		gameResult = 1
+		return 1, true, nil
 	case GameResultBlackWin:
-		gameResult = 0
+		return 0, true, nil
 	case GameResultDraw:
-		gameResult = 0.5
-	default:
-		return nil, fmt.Errorf("bad game result")
+		return 0.5, true, nil
+	case GameResultNone:
+		if !settings.Adjudicate {
+			return 0, false, newGameError(ReasonBadResult, 0, "unfinished game")
+		}
+		var gameResult, reason, ok = adjudicate(game.Items, settings)
+		if !ok {
+			return 0, false, newGameError(ReasonNotAdjudicated, 0, "unfinished game")
+		}
+		stats.Add(adjudicationStatsPrefix+reason, 1)
+		return gameResult, true, nil
 	}
+	return 0, false, newGameError(ReasonBadResult, 0, "%v", sGameResult)
+}
 
-	var repeatPositions = make(map[uint64]struct{})
-	var result []PositionInfo
+type lineAnalyzer struct {
+	filters         *FilterChain
+	settings        *AnalyzeSettings
+	variations      bool
+	variationResult float32
+	repeatPositions map[uint64]int
+	bookPlies       int
+	tags            []Tag
+	result          []PositionInfo
+}
 
-	for i := range game.Items {
+// analyzeLine analyzes line which starts at gamePly of game, ply is gamePly counted from initial position.
+func (la *lineAnalyzer) analyzeLine(items []Item, gamePly, ply int, gameResult float32) {
+	for i := range items {
 		if i > 0 {
-			repeatPositions[game.Items[i-1].Position.Key] = struct{}{}
+			la.repeatPositions[items[i-1].Position.Key]++
 		}
 
-		var item = &game.Items[i]
+		var item = &items[i]
 
-		if item.Comment.Depth < 10 ||
-			item.Comment.Score.Mate != 0 ||
-			item.Position.IsCheck() {
-			continue
+		if la.variations && len(item.Variations) != 0 {
+			// variation starts from position of item, which is analyzed in this line
+			la.repeatPositions[item.Position.Key]++
+			for _, variation := range item.Variations {
+				la.analyzeLine(variation[1:], gamePly+i+1, ply+i+1, la.variationResult)
+			}
+			if la.repeatPositions[item.Position.Key]--; la.repeatPositions[item.Position.Key] == 0 {
+				delete(la.repeatPositions, item.Position.Key)
+			}
 		}
-		if _, found := repeatPositions[item.Position.Key]; found {
-			continue
+
+		var pvMove string
+		if len(item.Comment.PV) != 0 {
+			pvMove = item.Comment.PV[0]
 		}
-		if !quietService.IsQuiet(&item.Position) {
+		if !la.filters.Accept(&Candidate{
+			Position:    &item.Position,
+			Castling:    item.Castling,
+			Evaluated:   item.Evaluated,
+			Score:       item.Comment.Score,
+			Depth:       item.Comment.Depth,
+			Ply:         ply + i,
+			GamePly:     gamePly + i,
+			Repetitions: la.repeatPositions[item.Position.Key],
+			BookPlies:   la.bookPlies,
+			Move:        item.SanMove,
+			PVMove:      pvMove,
+			GameResult:  gameResult,
+			Tags:        la.tags,
+		}) {
 			continue
 		}
 
-		result = append(result, PositionInfo{
+		la.result = append(la.result, PositionInfo{
 			position:   item.Position,
-			score:      item.Comment.Score.Centipawns,
+			castling:   item.Castling,
+			score:      la.settings.centipawns(item.Comment.Score),
 			gameResult: gameResult,
 		})
 	}
 
-	return result, nil
+	// restore history for the caller line
+	for i := 0; i < len(items)-1; i++ {
+		var key = items[i].Position.Key
+		if la.repeatPositions[key]--; la.repeatPositions[key] == 0 {
+			delete(la.repeatPositions, key)
+		}
+	}
+}
+
+// centipawns converts score to centipawns, mate scores are expected to be kept.
+func (settings *AnalyzeSettings) centipawns(score common.UciScore) int {
+	if score.Mate == 0 {
+		return score.Centipawns
+	}
+	var distance = score.Mate
+	if distance < 0 {
+		distance = -distance
+	}
+	var result = max(settings.MateScore-settings.MateStep*(distance-1), settings.MateScore/2)
+	if score.Mate < 0 {
+		return -result
+	}
+	return result
 }
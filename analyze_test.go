@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestMateScore(t *testing.T) {
+	for _, test := range []struct {
+		score    string
+		step     int
+		expected int
+	}{
+		{"+0.36", 100, 36},
+		{"#1", 0, 3000},
+		{"#3", 0, 3000},
+		{"-M5", 0, -3000},
+		{"+M12", 0, 3000},
+		{"#1", 100, 3000},
+		{"#3", 100, 2800},
+		{"#-3", 100, -2800},
+		{"-M5", 100, -2600},
+		{"+M12", 100, 1900},
+		// not below half of mate score
+		{"+M12", 200, 1500},
+		{"-M12", 200, -1500},
+	} {
+		var score, ok = parseScore(test.score)
+		if !ok {
+			t.Fatalf("%v: not parsed", test.score)
+		}
+		var settings = &AnalyzeSettings{Mate: MateKeep, MateScore: 3000, MateStep: test.step}
+		if centipawns := settings.centipawns(score); centipawns != test.expected {
+			t.Errorf("%v step %v: got %v, expected %v", test.score, test.step, centipawns, test.expected)
+		}
+	}
+}
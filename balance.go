@@ -0,0 +1,276 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	BalanceMaterial = "material"
+	BalancePieces   = "pieces"
+	BalancePhase    = "phase"
+	BalanceKing     = "king"
+)
+
+var balanceKeys = []string{BalanceMaterial, BalancePieces, BalancePhase, BalanceKing}
+
+const balanceStatsPrefix = "balance: "
+
+// BalanceSettings configures balancing stage between analysis and output.
+// Positions are classified into buckets by Key, each bucket keeps at most its quota:
+// Quota for every bucket or share of Total by Proportions "bucket=weight",
+// where "*" is the common share of all other buckets (they are dropped without it).
+// Kept positions are the ones with the smallest hash of seed and position (bottom-k reservoir),
+// so memory is bounded by quotas and result does not depend on order of positions.
+// Without quotas positions are only counted.
+type BalanceSettings struct {
+	Key         string
+	Quota       int
+	Total       int
+	Proportions []string
+}
+
+func (s *BalanceSettings) enabled() bool {
+	return s.Key != ""
+}
+
+func (s *BalanceSettings) validate() error {
+	if !s.enabled() {
+		if s.Quota != 0 || s.Total != 0 || len(s.Proportions) != 0 {
+			return fmt.Errorf("balance quotas require balance key")
+		}
+		return nil
+	}
+	if !containsString(balanceKeys, s.Key) {
+		return fmt.Errorf("unknown balance key %v, expected one of %v", s.Key, strings.Join(balanceKeys, ", "))
+	}
+	if (len(s.Proportions) != 0) != (s.Total > 0) {
+		return fmt.Errorf("balance proportions and balance total are used together")
+	}
+	if len(s.Proportions) != 0 && s.Quota != 0 {
+		return fmt.Errorf("balance quota can not be used with balance proportions")
+	}
+	_, err := s.weights()
+	return err
+}
+
+// weights returns normalized proportions.
+func (s *BalanceSettings) weights() (map[string]float64, error) {
+	var result = make(map[string]float64)
+	var sum float64
+	for _, item := range s.Proportions {
+		var index = strings.IndexByte(item, '=')
+		if index < 0 {
+			return nil, fmt.Errorf("bad balance proportion %v, expected bucket=weight", item)
+		}
+		var weight, err = strconv.ParseFloat(item[index+1:], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("bad balance proportion %v, expected bucket=weight", item)
+		}
+		result[item[:index]] = weight
+		sum += weight
+	}
+	if len(result) != 0 && sum == 0 {
+		return nil, fmt.Errorf("balance proportions are zero")
+	}
+	for bucket := range result {
+		result[bucket] /= sum
+	}
+	return result, nil
+}
+
+// quotaGroup returns group of buckets sharing quota and the quota, -1 if not limited.
+func (s *BalanceSettings) quotaGroup(weights map[string]float64, bucket string) (string, int) {
+	if len(weights) != 0 {
+		var group = bucket
+		if _, found := weights[bucket]; !found {
+			group = "*"
+		}
+		return group, int(math.Round(weights[group] * float64(s.Total)))
+	}
+	if s.Quota > 0 {
+		return bucket, s.Quota
+	}
+	return bucket, -1
+}
+
+// balanceBucket classifies position by key.
+func balanceBucket(key string, p *common.Position) string {
+	switch key {
+	case BalanceMaterial:
+		return materialSignature(p)
+	case BalancePieces:
+		return strconv.Itoa(common.PopCount(p.White | p.Black))
+	case BalancePhase:
+		return gamePhase(p)
+	}
+	// king square of side to move from its own side, e.g. e1 for king on initial square
+	var king = common.FirstOne(p.Kings & p.White)
+	if !p.WhiteMove {
+		king = common.FlipSquare(common.FirstOne(p.Kings & p.Black))
+	}
+	return common.SquareName(king)
+}
+
+// materialSignature is e.g. KRPPvKR, side with more material first.
+func materialSignature(p *common.Position) string {
+	var side = func(own uint64) string {
+		var sb strings.Builder
+		for _, piece := range []struct {
+			letter string
+			pieces uint64
+		}{{"K", p.Kings}, {"Q", p.Queens}, {"R", p.Rooks}, {"B", p.Bishops}, {"N", p.Knights}, {"P", p.Pawns}} {
+			sb.WriteString(strings.Repeat(piece.letter, common.PopCount(piece.pieces&own)))
+		}
+		return sb.String()
+	}
+	var white, black = side(p.White), side(p.Black)
+	if materialValue(p, p.Black) > materialValue(p, p.White) ||
+		materialValue(p, p.Black) == materialValue(p, p.White) && black > white {
+		white, black = black, white
+	}
+	return white + "v" + black
+}
+
+func materialValue(p *common.Position, own uint64) int {
+	return 9*common.PopCount(p.Queens&own) + 5*common.PopCount(p.Rooks&own) +
+		3*common.PopCount((p.Bishops|p.Knights)&own) + common.PopCount(p.Pawns&own)
+}
+
+type balanceItem struct {
+	hash     uint64
+	position PositionInfo
+}
+
+// balanceReservoir is max heap by hash keeping positions with the smallest hashes.
+type balanceReservoir []balanceItem
+
+func (r balanceReservoir) Len() int            { return len(r) }
+func (r balanceReservoir) Less(i, j int) bool  { return r[i].hash > r[j].hash }
+func (r balanceReservoir) Swap(i, j int)       { r[i], r[j] = r[j], r[i] }
+func (r *balanceReservoir) Push(x interface{}) { *r = append(*r, x.(balanceItem)) }
+func (r *balanceReservoir) Pop() interface{} {
+	var old = *r
+	var item = old[len(old)-1]
+	*r = old[:len(old)-1]
+	return item
+}
+
+type balanceGroup struct {
+	quota     int
+	reservoir balanceReservoir
+}
+
+// balancePositions passes positions of games through buckets. Positions of limited buckets
+// are kept until input is closed and written in random order in batches.
+func balancePositions(
+	ctx context.Context,
+	settings BalanceSettings,
+	seed int64,
+	stats *Stats,
+	games <-chan []PositionInfo,
+	balanced chan<- []PositionInfo,
+) error {
+	var weights, err = settings.weights()
+	if err != nil {
+		return err
+	}
+	var send = func(positions []PositionInfo) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case balanced <- positions:
+			return nil
+		}
+	}
+
+	var groups = make(map[string]*balanceGroup)
+	var seen = make(map[string]int64)
+	var kept = make(map[string]int64)
+	for game := range games {
+		var passed []PositionInfo
+		for i := range game {
+			var item = &game[i]
+			var bucket = balanceBucket(settings.Key, &item.position)
+			seen[bucket]++
+			var groupName, quota = settings.quotaGroup(weights, bucket)
+			if quota < 0 {
+				kept[bucket]++
+				passed = append(passed, *item)
+				continue
+			}
+			var group = groups[groupName]
+			if group == nil {
+				group = &balanceGroup{quota: quota}
+				groups[groupName] = group
+			}
+			var hash = balanceHash(seed, item)
+			if len(group.reservoir) < group.quota {
+				heap.Push(&group.reservoir, balanceItem{hash, *item})
+			} else if group.quota != 0 && hash < group.reservoir[0].hash {
+				group.reservoir[0] = balanceItem{hash, *item}
+				heap.Fix(&group.reservoir, 0)
+			}
+		}
+		if len(passed) != 0 {
+			if err := send(passed); err != nil {
+				return err
+			}
+		}
+	}
+
+	var reservoirs []balanceItem
+	for _, group := range groups {
+		reservoirs = append(reservoirs, group.reservoir...)
+	}
+	sort.Slice(reservoirs, func(i, j int) bool { return reservoirs[i].hash < reservoirs[j].hash })
+	for len(reservoirs) != 0 {
+		var n = min(len(reservoirs), lineBatchSize)
+		var batch = make([]PositionInfo, n)
+		for i := range batch {
+			batch[i] = reservoirs[i].position
+			kept[balanceBucket(settings.Key, &batch[i].position)]++
+		}
+		reservoirs = reservoirs[n:]
+		if err := send(batch); err != nil {
+			return err
+		}
+	}
+
+	logBalance(settings.Key, stats, seen, kept)
+	return nil
+}
+
+func logBalance(key string, stats *Stats, seen, kept map[string]int64) {
+	var names = make([]string, 0, len(seen))
+	var total int64
+	for name := range seen {
+		names = append(names, name)
+		total += kept[name]
+	}
+	sort.Slice(names, func(i, j int) bool { return seen[names[i]] > seen[names[j]] })
+	log.Printf("Balance by %v: bucket, seen, kept, share", key)
+	for _, name := range names {
+		var share float64
+		if total != 0 {
+			share = float64(kept[name]) / float64(total)
+		}
+		log.Printf("%v %v %v %.4f", name, seen[name], kept[name], share)
+		stats.Add(balanceStatsPrefix+name+" seen", seen[name])
+		stats.Add(balanceStatsPrefix+name+" kept", kept[name])
+	}
+}
+
+func balanceHash(seed int64, item *PositionInfo) uint64 {
+	var x = splitMix64(uint64(seed) ^ item.position.Key)
+	x = splitMix64(x ^ uint64(int64(item.score)))
+	return splitMix64(x ^ uint64(math.Float32bits(item.gameResult)))
+}
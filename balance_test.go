@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestBalance(t *testing.T) {
+	for _, test := range []struct {
+		fen                   string
+		material, king, phase string
+	}{
+		{common.InitialPositionFen, "KQRRBBNNPPPPPPPPvKQRRBBNNPPPPPPPP", "e1", PhaseOpening},
+		{"4k3/4p3/8/8/8/8/8/4K2R b - - 0 40", "KRvKP", "e1", PhaseEndgame},
+		{"6k1/8/8/8/8/8/1r6/K7 w - - 0 60", "KRvK", "a1", PhaseEndgame},
+		{"7k/8/8/8/8/8/1R6/K7 b - - 0 60", "KRvK", "h1", PhaseEndgame},
+	} {
+		var p, err = common.NewPositionFromFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if material := balanceBucket(BalanceMaterial, &p); material != test.material {
+			t.Errorf("%v: got material %v", test.fen, material)
+		}
+		if king := balanceBucket(BalanceKing, &p); king != test.king {
+			t.Errorf("%v: got king %v", test.fen, king)
+		}
+		if phase := balanceBucket(BalancePhase, &p); phase != test.phase {
+			t.Errorf("%v: got phase %v", test.fen, phase)
+		}
+	}
+
+	var opening, _ = common.NewPositionFromFEN(common.InitialPositionFen)
+	var endgame, _ = common.NewPositionFromFEN("4k3/4p3/8/8/8/8/8/4K2R b - - 0 40")
+	var games [][]PositionInfo
+	for i := 0; i < 100; i++ {
+		var game []PositionInfo
+		for j := 0; j < 10; j++ {
+			var p = opening
+			if j >= 8 {
+				p = endgame
+			}
+			game = append(game, PositionInfo{position: p, score: i*10 + j})
+		}
+		games = append(games, game)
+	}
+
+	var run = func(settings BalanceSettings, games [][]PositionInfo) map[string][]int {
+		var input = make(chan []PositionInfo, len(games))
+		for _, game := range games {
+			input <- game
+		}
+		close(input)
+		var output = make(chan []PositionInfo, 10000)
+		if err := balancePositions(context.Background(), settings, 1, nil, input, output); err != nil {
+			t.Fatal(err)
+		}
+		close(output)
+		var result = make(map[string][]int)
+		for batch := range output {
+			for _, item := range batch {
+				var phase = gamePhase(&item.position)
+				result[phase] = append(result[phase], item.score)
+			}
+		}
+		for _, scores := range result {
+			sort.Ints(scores)
+		}
+		return result
+	}
+
+	var result = run(BalanceSettings{Key: BalancePhase, Quota: 150}, games)
+	if len(result[PhaseOpening]) != 150 || len(result[PhaseEndgame]) != 150 {
+		t.Errorf("quota: got %v opening, %v endgame", len(result[PhaseOpening]), len(result[PhaseEndgame]))
+	}
+	var reversed = make([][]PositionInfo, len(games))
+	for i := range games {
+		reversed[len(games)-1-i] = games[i]
+	}
+	if !reflect.DeepEqual(run(BalanceSettings{Key: BalancePhase, Quota: 150}, reversed), result) {
+		t.Error("balance depends on order of games")
+	}
+
+	var settings = BalanceSettings{Key: BalancePhase, Total: 200, Proportions: []string{"endgame=1", "*=3"}}
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+	result = run(settings, games)
+	if len(result[PhaseOpening]) != 150 || len(result[PhaseEndgame]) != 50 {
+		t.Errorf("proportions: got %v opening, %v endgame", len(result[PhaseOpening]), len(result[PhaseEndgame]))
+	}
+
+	result = run(BalanceSettings{Key: BalancePieces}, games)
+	if len(result[PhaseOpening]) != 800 || len(result[PhaseEndgame]) != 200 {
+		t.Errorf("count only: got %v opening, %v endgame", len(result[PhaseOpening]), len(result[PhaseEndgame]))
+	}
+
+	for _, bad := range []BalanceSettings{
+		{Key: "color"},
+		{Quota: 10},
+		{Key: BalancePhase, Proportions: []string{"endgame=1"}},
+		{Key: BalancePhase, Total: 10},
+		{Key: BalancePhase, Total: 10, Proportions: []string{"endgame=0"}},
+		{Key: BalancePhase, Total: 10, Proportions: []string{"endgame"}},
+	} {
+		if bad.validate() == nil {
+			t.Errorf("%+v: validated", bad)
+		}
+	}
+}
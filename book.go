@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+// OpeningBook is set of book positions read from EPD or FEN file.
+// Positions are compared by board, side to move and castling,
+// because en passant square is written differently by tools.
+type OpeningBook struct {
+	positions map[string]struct{}
+}
+
+func LoadOpeningBook(filepath string) (*OpeningBook, error) {
+	if strings.HasSuffix(strings.ToLower(filepath), ".bin") {
+		return nil, fmt.Errorf("polyglot book %v is not supported, use EPD or FEN list of book positions", filepath)
+	}
+	file, err := openPgnFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result = &OpeningBook{positions: make(map[string]struct{})}
+	var scanner = bufio.NewScanner(file)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		var line = strings.TrimSpace(strings.TrimPrefix(scanner.Text(), utf8BOM))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var fields = strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%v:%v: bad book position %v", filepath, lineNumber, line)
+		}
+		p, castling, err := parseAnyFEN(strings.Join(fields[:4], " ") + " 0 1")
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", filepath, lineNumber, err)
+		}
+		result.positions[bookKey(&p, castling)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *OpeningBook) Contains(p *common.Position, castling Castling) bool {
+	if b == nil {
+		return false
+	}
+	var _, found = b.positions[bookKey(p, castling)]
+	return found
+}
+
+func bookKey(p *common.Position, castling Castling) string {
+	var fields = strings.SplitN(formatFEN(p, castling, CastlingShredder), " ", 4)
+	return fields[0] + " " + fields[1] + " " + fields[2]
+}
+
+// bookPlies returns number of book plies at the start of main line.
+// Move is book if it is commented {book}, has pseudo eval of depth 1 in zero time like {+0.00/1 0s}
+// or leads to position of opening book. Book ends at the first other move with engine eval,
+// moves without eval before it are book if a later book move follows or with untilEval.
+func bookPlies(items []Item, book *OpeningBook, untilEval bool) int {
+	var result = 0
+	for i := range items {
+		var item = &items[i]
+		if isBookComment(item) ||
+			i+1 < len(items) && book.Contains(&items[i+1].Position, items[i+1].Castling) {
+			result = i + 1
+		} else if item.Evaluated {
+			break
+		} else if untilEval {
+			result = i + 1
+		}
+	}
+	return result
+}
+
+func isBookComment(item *Item) bool {
+	return strings.EqualFold(strings.TrimSpace(item.TxtComment), "book") ||
+		item.Evaluated && item.Comment.Depth == 1 && item.Comment.Time == 0
+}
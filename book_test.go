@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBook(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var bookPath = filepath.Join(dir, "book.epd")
+	err = ioutil.WriteFile(bookPath, []byte("# 1. e4 e5 2. Nf3\nrnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - c0 \"Open game\";\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := LoadOpeningBook(bookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOpeningBook(filepath.Join(dir, "book.bin")); err == nil {
+		t.Error("polyglot book loaded")
+	}
+
+	for _, test := range []struct {
+		movetext  string
+		book      *OpeningBook
+		untilEval bool
+		plies     int
+	}{
+		{"1. e4 {book} e5 {Book} 2. Nf3 {+0.30/20 1.5s} Nc6 {-0.20/18 1.2s} *", nil, false, 2},
+		{"1. e4 {+0.00/1 0s} e5 {+0.00/1 0s} 2. Nf3 {+0.30/20 1.5s} *", nil, false, 2},
+		{"1. e4 e5 2. Nf3 Nc6 {-0.20/18 1.2s} 3. Bb5 {+0.30/20 1.5s} *", nil, false, 0},
+		{"1. e4 e5 2. Nf3 Nc6 {-0.20/18 1.2s} 3. Bb5 {+0.30/20 1.5s} *", nil, true, 3},
+		{"1. e4 e5 2. Nf3 Nc6 {-0.20/18 1.2s} 3. Bb5 {+0.30/20 1.5s} *", book, false, 3},
+		{"1. e4 e5 2. Nf3 {+0.30/20 1.5s} Nc6 {-0.20/18 1.2s} *", book, true, 3},
+		{"1. e4 {book} e5 {book} 2. Nf3 {+0.30/20 1.5s} Nc6 {-0.20/18 1.2s} 3. Bb5 {+0.30/20 1.5s} a6 {-0.20/18 1.2s} 4. Ba4 {+0.30/1 0s} *", nil, true, 2},
+		{"1. e4 {+0.20/20 1.5s} e5 {-0.20/18 1.2s} 2. Nf3 {+0.30/20 1.5s} Nc6 {-0.20/18 1.2s} *", book, false, 0},
+		{"1. e4 {+0.20/20 1.5s} e5 {-0.20/18 1.2s} 2. Nf3 {+0.30/20 1.5s} Nc6 {-0.20/18 1.2s} *", book, true, 0},
+	} {
+		var game, err = ParseGame("[Result \"*\"]\n\n"+test.movetext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plies := bookPlies(game.Items, test.book, test.untilEval); plies != test.plies {
+			t.Errorf("%v: got %v book plies, expected %v", test.movetext, plies, test.plies)
+		}
+	}
+
+	var settings = &AnalyzeSettings{}
+	all, err := AnalyzeGame(&AllQuietService{}, settings, nil, pgn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.SkipBook = true
+	settings.BookSkipPlies = 2
+	var stats = NewStats()
+	positions, err := AnalyzeGame(&AllQuietService{}, settings, nil, pgn, stats)
+	if err != nil || len(positions) != len(all)-18 || stats.Counts()[filterStatsPrefix+"book"] != 18 {
+		t.Errorf("got %v of %v positions, %v %v", len(positions), len(all), err, stats.Counts())
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	CastlingXFEN     = "xfen"
+	CastlingShredder = "shredder"
+)
+
+const (
+	castleWhiteKingSide = iota
+	castleWhiteQueenSide
+	castleBlackKingSide
+	castleBlackQueenSide
+)
+
+// Castling keeps castling rights of Chess960 game by rook files,
+// because common.Position supports only standard castling.
+// Positions of Chess960 game have zero CastleRights.
+// For standard game Chess960 is false and rights are taken from Position.CastleRights.
+type Castling struct {
+	Chess960 bool
+	Rooks    [4]int8 // file of castling rook, -1 if right is lost
+}
+
+var noCastling = Castling{Chess960: true, Rooks: [4]int8{-1, -1, -1, -1}}
+
+func isChess960(tags []Tag) bool {
+	var variant, found = tagValue(tags, "Variant")
+	if !found {
+		return false
+	}
+	switch strings.ToLower(strings.Replace(variant, " ", "", -1)) {
+	case "chess960", "960", "fischerandom", "fischerrandom", "frc":
+		return true
+	}
+	return false
+}
+
+// parseFEN960 parses FEN with X-FEN (KQkq, file letters for inner rooks)
+// or Shredder-FEN (file letters) castling field.
+func parseFEN960(fen string) (common.Position, Castling, error) {
+	var fields = strings.Fields(fen)
+	if len(fields) < 4 {
+		return common.Position{}, Castling{}, fmt.Errorf("parse fen failed %v", fen)
+	}
+	var castlingField = fields[2]
+	fields[2] = "-"
+	var p, err = common.NewPositionFromFEN(strings.Join(fields, " "))
+	if err != nil {
+		return common.Position{}, Castling{}, err
+	}
+	var castling = noCastling
+	if castlingField == "-" {
+		return p, castling, nil
+	}
+	for _, c := range castlingField {
+		var white = 'A' <= c && c <= 'Z'
+		var rank = common.Rank1
+		var offset = castleWhiteKingSide
+		if !white {
+			rank = common.Rank8
+			offset = castleBlackKingSide
+		}
+		var kingFile = common.File(p.KingSq(white))
+		var rooks = p.Rooks & p.PiecesByColor(white) & (common.Rank1Mask << uint(8*rank))
+		var file = -1
+		switch c {
+		case 'K', 'k':
+			for f := common.FileH; f > kingFile; f-- {
+				if rooks&common.SquareMask[common.MakeSquare(f, rank)] != 0 {
+					file = f
+					break
+				}
+			}
+		case 'Q', 'q':
+			for f := common.FileA; f < kingFile; f++ {
+				if rooks&common.SquareMask[common.MakeSquare(f, rank)] != 0 {
+					file = f
+					break
+				}
+			}
+		default:
+			var lower = c | 0x20
+			if 'a' <= lower && lower <= 'h' {
+				file = int(lower - 'a')
+			}
+		}
+		if file < 0 || file == kingFile ||
+			rooks&common.SquareMask[common.MakeSquare(file, rank)] == 0 {
+			return common.Position{}, Castling{}, fmt.Errorf("parse fen failed %v: bad castling", fen)
+		}
+		if file > kingFile {
+			castling.Rooks[offset] = int8(file)
+		} else {
+			castling.Rooks[offset+1] = int8(file)
+		}
+	}
+	return p, castling, nil
+}
+
+// normalizeShredderCastling converts Shredder-FEN castling field of standard game into KQkq.
+func normalizeShredderCastling(fen string) string {
+	var fields = strings.Fields(fen)
+	if len(fields) < 3 || strings.Trim(fields[2], "KQkq-") == "" {
+		return fen
+	}
+	fields[2] = strings.NewReplacer("H", "K", "A", "Q", "h", "k", "a", "q").Replace(fields[2])
+	return strings.Join(fields, " ")
+}
+
+// parseAnyFEN parses FEN of standard or Chess960 position with KQkq, X-FEN or Shredder-FEN castling field.
+// Position is standard when all castling rights belong to king on e-file and rooks in the corners.
+func parseAnyFEN(fen string) (common.Position, Castling, error) {
+	// on error FEN is parsed as standard, which tolerates castling rights without rooks
+	if p, castling, err := parseFEN960(fen); err == nil && !isStandardCastling(&p, castling) {
+		return p, castling, nil
+	}
+	var p, err = common.NewPositionFromFEN(normalizeShredderCastling(fen))
+	if err != nil {
+		return common.Position{}, Castling{}, err
+	}
+	return p, Castling{}, nil
+}
+
+func isStandardCastling(p *common.Position, castling Castling) bool {
+	for i, file := range castling.Rooks {
+		if file < 0 {
+			continue
+		}
+		var cornerFile = common.FileH
+		if i%2 == 1 {
+			cornerFile = common.FileA
+		}
+		if common.File(p.KingSq(i < castleBlackKingSide)) != common.FileE || int(file) != cornerFile {
+			return false
+		}
+	}
+	return true
+}
+
+// makeMoveSAN applies SAN move and updates Chess960 castling rights.
+// Chess960 castling may be written as O-O/O-O-O or as king takes own rook.
+func makeMoveSAN(p *common.Position, castling Castling, san string) (common.Position, Castling, bool) {
+	san = normalizeCastling(san)
+	var child common.Position
+	if !castling.Chess960 {
+		var move = common.ParseMoveSAN(p, san)
+		if move == common.MoveEmpty || !p.MakeMove(move, &child) {
+			return common.Position{}, castling, false
+		}
+		return child, castling, true
+	}
+
+	var bare = strings.TrimRight(san, "+#!?")
+	switch bare {
+	case "O-O":
+		return castle960(p, castling, true)
+	case "O-O-O":
+		return castle960(p, castling, false)
+	}
+
+	var move = common.ParseMoveSAN(p, san)
+	if move == common.MoveEmpty {
+		if kingSide, ok := kingTakesRook(p, castling, bare); ok {
+			return castle960(p, castling, kingSide)
+		}
+		return common.Position{}, castling, false
+	}
+	if !p.MakeMove(move, &child) {
+		return common.Position{}, castling, false
+	}
+	var side = 0
+	if !p.WhiteMove {
+		side = 2
+	}
+	for i := range castling.Rooks {
+		if castling.Rooks[i] < 0 {
+			continue
+		}
+		var rank = common.Rank1
+		if i >= castleBlackKingSide {
+			rank = common.Rank8
+		}
+		var rookSq = common.MakeSquare(int(castling.Rooks[i]), rank)
+		if move.From() == rookSq || move.To() == rookSq ||
+			move.MovingPiece() == common.King && (i == side || i == side+1) {
+			castling.Rooks[i] = -1
+		}
+	}
+	return child, castling, true
+}
+
+// kingTakesRook recognizes castling written as king move to own castling rook square, e.g. Kxh1.
+func kingTakesRook(p *common.Position, castling Castling, san string) (kingSide bool, ok bool) {
+	san = strings.Replace(san, "x", "", 1)
+	if len(san) != 3 || san[0] != 'K' {
+		return false, false
+	}
+	var to = common.ParseSquare(san[1:])
+	var offset = castleWhiteKingSide
+	var rank = common.Rank1
+	if !p.WhiteMove {
+		offset = castleBlackKingSide
+		rank = common.Rank8
+	}
+	if common.Rank(to) != rank {
+		return false, false
+	}
+	if int(castling.Rooks[offset]) == common.File(to) {
+		return true, true
+	}
+	if int(castling.Rooks[offset+1]) == common.File(to) {
+		return false, true
+	}
+	return false, false
+}
+
+// castle960 makes Chess960 castling: king goes to g/c file, rook goes to f/d file.
+func castle960(p *common.Position, castling Castling, kingSide bool) (common.Position, Castling, bool) {
+	var white = p.WhiteMove
+	var offset = castleWhiteKingSide
+	var rank = common.Rank1
+	if !white {
+		offset = castleBlackKingSide
+		rank = common.Rank8
+	}
+	var rookFile = int(castling.Rooks[offset])
+	var kingToFile, rookToFile = common.FileG, common.FileF
+	if !kingSide {
+		rookFile = int(castling.Rooks[offset+1])
+		kingToFile, rookToFile = common.FileC, common.FileD
+	}
+	if rookFile < 0 || p.IsCheck() {
+		return common.Position{}, castling, false
+	}
+
+	var own = p.PiecesByColor(white)
+	var kingFrom = p.KingSq(white)
+	var rookFrom = common.MakeSquare(rookFile, rank)
+	var kingTo = common.MakeSquare(kingToFile, rank)
+	var rookTo = common.MakeSquare(rookToFile, rank)
+	if p.Rooks&own&common.SquareMask[rookFrom] == 0 {
+		return common.Position{}, castling, false
+	}
+
+	var occ = p.AllPieces() &^ (common.SquareMask[kingFrom] | common.SquareMask[rookFrom])
+	if occ&(squaresBetween(kingFrom, kingTo)|squaresBetween(rookFrom, rookTo)) != 0 {
+		return common.Position{}, castling, false
+	}
+	var path = squaresBetween(kingFrom, kingTo)
+	for path != 0 {
+		var sq = common.FirstOne(path)
+		path &= path - 1
+		if isAttacked(p, sq, !white, occ) {
+			return common.Position{}, castling, false
+		}
+	}
+
+	var board = *p
+	if white {
+		// king and rook masks may overlap when one of them stays or they swap squares
+		board.White = board.White&^(common.SquareMask[kingFrom]|common.SquareMask[rookFrom]) |
+			common.SquareMask[kingTo] | common.SquareMask[rookTo]
+	} else {
+		board.Black = board.Black&^(common.SquareMask[kingFrom]|common.SquareMask[rookFrom]) |
+			common.SquareMask[kingTo] | common.SquareMask[rookTo]
+	}
+	board.Rooks = board.Rooks&^(common.SquareMask[rookFrom]|common.SquareMask[rookTo]) | common.SquareMask[rookTo]
+	board.Kings = board.Kings&^(common.SquareMask[kingFrom]|common.SquareMask[kingTo]) | common.SquareMask[kingTo]
+	board.WhiteMove = !white
+	board.EpSquare = common.SquareNone
+	board.CastleRights = 0
+	board.Rule50++
+
+	// FEN round trip computes key and checkers and checks legality
+	var child, err = common.NewPositionFromFEN(board.String())
+	if err != nil {
+		return common.Position{}, castling, false
+	}
+	castling.Rooks[offset] = -1
+	castling.Rooks[offset+1] = -1
+	return child, castling, true
+}
+
+// squaresBetween returns squares on the same rank from sq1 to sq2 inclusive.
+func squaresBetween(sq1, sq2 int) uint64 {
+	if sq1 > sq2 {
+		sq1, sq2 = sq2, sq1
+	}
+	var result uint64
+	for sq := sq1; sq <= sq2; sq++ {
+		result |= common.SquareMask[sq]
+	}
+	return result
+}
+
+func isAttacked(p *common.Position, sq int, bySide bool, occ uint64) bool {
+	var enemy = p.PiecesByColor(bySide)
+	return common.PawnAttacks(sq, !bySide)&p.Pawns&enemy != 0 ||
+		common.KnightAttacks[sq]&p.Knights&enemy != 0 ||
+		common.KingAttacks[sq]&p.Kings&enemy != 0 ||
+		common.BishopAttacks(sq, occ)&(p.Bishops|p.Queens)&enemy != 0 ||
+		common.RookAttacks(sq, occ)&(p.Rooks|p.Queens)&enemy != 0
+}
+
+// formatFEN writes position with castling field in X-FEN or Shredder-FEN notation.
+func formatFEN(p *common.Position, castling Castling, castlingFormat string) string {
+	var fen = p.String()
+	if !castling.Chess960 && castlingFormat != CastlingShredder {
+		return fen
+	}
+	var rooks = castling.Rooks
+	if !castling.Chess960 {
+		rooks = [4]int8{-1, -1, -1, -1}
+		for i, right := range []int{common.WhiteKingSide, common.WhiteQueenSide,
+			common.BlackKingSide, common.BlackQueenSide} {
+			if p.CastleRights&right != 0 {
+				rooks[i] = int8(common.FileH)
+				if i%2 == 1 {
+					rooks[i] = int8(common.FileA)
+				}
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for i, file := range rooks {
+		if file < 0 {
+			continue
+		}
+		var white = i < castleBlackKingSide
+		var kingSide = i%2 == 0
+		var c byte
+		if castlingFormat == CastlingShredder || !isOutermostRook(p, white, kingSide, int(file)) {
+			c = 'A' + byte(file)
+		} else if kingSide {
+			c = 'K'
+		} else {
+			c = 'Q'
+		}
+		if !white {
+			c |= 0x20
+		}
+		sb.WriteByte(c)
+	}
+	var castlingField = sb.String()
+	if castlingField == "" {
+		castlingField = "-"
+	}
+
+	var fields = strings.SplitN(fen, " ", 4)
+	return fields[0] + " " + fields[1] + " " + castlingField + " " + fields[3]
+}
+
+// isOutermostRook checks that there is no other own rook between castling rook and board edge.
+func isOutermostRook(p *common.Position, white, kingSide bool, file int) bool {
+	var rank = common.Rank1
+	if !white {
+		rank = common.Rank8
+	}
+	var rooks = p.Rooks & p.PiecesByColor(white)
+	var from, to = file + 1, common.FileH
+	if !kingSide {
+		from, to = common.FileA, file-1
+	}
+	for f := from; f <= to; f++ {
+		if rooks&common.SquareMask[common.MakeSquare(f, rank)] != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+// Comment is engine evaluation of the position before the move.
+// Score is from side to move point of view.
+// Fields not provided by GUI are zero, except unknown Depth which is -1.
+type Comment struct {
+	Depth    int
+	SelDepth int
+	Nodes    int64
+	Time     time.Duration
+	TBHits   int64
+	Score    common.UciScore
+	PV       []string
+	// AfterMove is set by parsers of evals of the position after the commented move (Lichess),
+	// ParseGame moves such comment to the next item.
+	AfterMove bool
+}
+
+// CommentParser recognizes evaluation comments written by one GUI.
+// whiteMove is the side which made the move commented.
+type CommentParser interface {
+	Parse(comment string, whiteMove bool) (Comment, bool)
+}
+
+const CommentFormatAuto = "auto"
+
+// commentParsers are tried in order by auto detection, so parsers accepting a superset
+// of other formats go first: arena accepts fastchess comments and fastchess accepts cutechess ones.
+var commentParsers = []struct {
+	name   string
+	parser CommentParser
+}{
+	{"lichess", &LichessCommentParser{}},
+	{"tcec", &KeyValueCommentParser{WhiteView: true}},
+	{"banksia", &KeyValueCommentParser{}},
+	{"arena", &CutechessCommentParser{Extended: true, PlainTime: true}},
+	{"fastchess", &CutechessCommentParser{Extended: true}},
+	{"cutechess", &CutechessCommentParser{}},
+}
+
+func commentParserByName(name string) (CommentParser, bool) {
+	for _, item := range commentParsers {
+		if item.name == name {
+			return item.parser, true
+		}
+	}
+	return nil, false
+}
+
+func commentFormatNames() []string {
+	var result = []string{CommentFormatAuto}
+	for _, item := range commentParsers {
+		result = append(result, item.name)
+	}
+	return result
+}
+
+// CommentSelector chooses comment parser for input file.
+// Rule is either "format" for all files or "glob=format" for matching files.
+// With auto format the parser is detected by the first recognized comment of each file.
+type CommentSelector struct {
+	rules []commentRule
+	mu    sync.Mutex
+	files map[string]CommentParser
+}
+
+type commentRule struct {
+	pattern string
+	format  string
+}
+
+func NewCommentSelector(rules []string) (*CommentSelector, error) {
+	var result = &CommentSelector{files: make(map[string]CommentParser)}
+	for _, rule := range rules {
+		var pattern, format = "", rule
+		if index := strings.LastIndex(rule, "="); index >= 0 {
+			pattern, format = rule[:index], rule[index+1:]
+		}
+		if _, ok := commentParserByName(format); !ok && format != CommentFormatAuto {
+			return nil, fmt.Errorf("unknown comment format %v, expected one of %v",
+				format, strings.Join(commentFormatNames(), ", "))
+		}
+		result.rules = append(result.rules, commentRule{pattern: pattern, format: format})
+	}
+	return result, nil
+}
+
+func (s *CommentSelector) Parser(file string) CommentParser {
+	var format = CommentFormatAuto
+	for _, rule := range s.rules {
+		if rule.pattern == "" || matchGlob(rule.pattern, file) {
+			format = rule.format
+			break
+		}
+	}
+	if parser, ok := commentParserByName(format); ok {
+		return parser
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parser, found = s.files[file]
+	if !found {
+		parser = &autoCommentParser{}
+		s.files[file] = parser
+	}
+	return parser
+}
+
+// autoCommentParser locks on the first parser which recognizes a comment.
+// Comments not recognized by it are parsed by any other parser.
+type autoCommentParser struct {
+	mu       sync.Mutex
+	detected CommentParser
+}
+
+func (p *autoCommentParser) Parse(comment string, whiteMove bool) (Comment, bool) {
+	p.mu.Lock()
+	var detected = p.detected
+	p.mu.Unlock()
+	if detected != nil {
+		if result, ok := detected.Parse(comment, whiteMove); ok {
+			return result, true
+		}
+	}
+	for _, item := range commentParsers {
+		if item.parser == detected {
+			continue
+		}
+		if result, ok := item.parser.Parse(comment, whiteMove); ok {
+			p.mu.Lock()
+			if p.detected == nil {
+				p.detected = item.parser
+			}
+			p.mu.Unlock()
+			return result, true
+		}
+	}
+	return Comment{}, false
+}
+
+// CutechessCommentParser parses "[(pv move)] score/depth time" like "(Qd2) +0.38/22 19s",
+// score may be mate "+M12"/"-M5". Score is from side to move point of view.
+// Extended format (fastchess) may be followed by ", key=value" pairs with nodes, seldepth, tbhits and pv.
+// PlainTime (Arena) allows time without unit in seconds.
+type CutechessCommentParser struct {
+	Extended  bool
+	PlainTime bool
+}
+
+func (p *CutechessCommentParser) Parse(comment string, whiteMove bool) (Comment, bool) {
+	var head, tail = comment, ""
+	if index := strings.Index(comment, ","); index >= 0 {
+		if !p.Extended {
+			return Comment{}, false
+		}
+		head, tail = comment[:index], comment[index+1:]
+	}
+
+	var fields = strings.Fields(head)
+	var result Comment
+	if len(fields) != 0 && strings.HasPrefix(fields[0], "(") {
+		result.PV = []string{strings.Trim(fields[0], "()")}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return Comment{}, false
+	}
+	var index = strings.Index(fields[0], "/")
+	if index < 0 {
+		return Comment{}, false
+	}
+	var score, scoreOk = parseScore(fields[0][:index])
+	var depth, depthErr = strconv.Atoi(fields[0][index+1:])
+	if !scoreOk || depthErr != nil {
+		return Comment{}, false
+	}
+	result.Score = score
+	result.Depth = depth
+
+	if len(fields) == 2 {
+		var duration, ok = parseDuration(fields[1], p.PlainTime)
+		if !ok {
+			return Comment{}, false
+		}
+		result.Time = duration
+	}
+
+	if tail != "" {
+		if !parseKeyValues(tail, &result, nil) {
+			return Comment{}, false
+		}
+	}
+	return result, true
+}
+
+// KeyValueCommentParser parses comma separated key=value comments.
+// TCEC style: "d=28, sd=47, mt=38478, n=1683889, tb=0, wv=0.36, pv=Nf3 d5 ..." (WhiteView: wv is white point of view).
+// BanksiaGUI style uses long keys: "depth=28, seldepth=47, time=38.4, nodes=1683889, score=0.36, pv=...".
+type KeyValueCommentParser struct {
+	WhiteView bool
+}
+
+func (p *KeyValueCommentParser) Parse(comment string, whiteMove bool) (Comment, bool) {
+	if !strings.Contains(comment, "=") {
+		return Comment{}, false
+	}
+	var result Comment
+	var score, scoreFound = common.UciScore{}, false
+	if !parseKeyValues(comment, &result, func(key, value string) {
+		if p.WhiteView && key == "wv" ||
+			!p.WhiteView && (key == "score" || key == "eval" || key == "ev") {
+			score, scoreFound = parseScore(value)
+		}
+	}) {
+		return Comment{}, false
+	}
+	if !scoreFound || result.Depth == 0 {
+		return Comment{}, false
+	}
+	if p.WhiteView && !whiteMove {
+		score = negateScore(score)
+	}
+	result.Score = score
+	return result, true
+}
+
+// LichessCommentParser parses "[%eval 0.36]", "[%eval #-3]" or "[%eval 0.36,25]" commands.
+// Lichess evaluation is of the position after the move from white point of view,
+// score is converted to side to move of that position.
+type LichessCommentParser struct{}
+
+func (p *LichessCommentParser) Parse(comment string, whiteMove bool) (Comment, bool) {
+	var index = strings.Index(comment, "[%eval ")
+	if index < 0 {
+		return Comment{}, false
+	}
+	var value = comment[index+len("[%eval "):]
+	var end = strings.IndexByte(value, ']')
+	if end < 0 {
+		return Comment{}, false
+	}
+	value = strings.TrimSpace(value[:end])
+
+	var result = Comment{Depth: -1}
+	if index := strings.IndexByte(value, ','); index >= 0 {
+		var depth, err = strconv.Atoi(value[index+1:])
+		if err != nil {
+			return Comment{}, false
+		}
+		result.Depth = depth
+		value = value[:index]
+	}
+	var score, ok = parseScore(value)
+	if !ok {
+		return Comment{}, false
+	}
+	if whiteMove {
+		score = negateScore(score)
+	}
+	result.Score = score
+	result.AfterMove = true
+	return result, true
+}
+
+// parseKeyValues fills common fields from "key=value" pairs.
+// Unknown keys are passed to custom, keys unknown for custom are ignored.
+func parseKeyValues(s string, result *Comment, custom func(key, value string)) bool {
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		var index = strings.IndexByte(pair, '=')
+		if index <= 0 {
+			return false
+		}
+		var key, value = strings.ToLower(pair[:index]), strings.TrimSpace(pair[index+1:])
+		var err error
+		switch key {
+		case "d", "depth":
+			result.Depth, err = strconv.Atoi(value)
+		case "sd", "seldepth":
+			result.SelDepth, err = strconv.Atoi(value)
+		case "n", "nodes":
+			result.Nodes, err = strconv.ParseInt(value, 10, 64)
+		case "tb", "tbhits":
+			result.TBHits, err = strconv.ParseInt(value, 10, 64)
+		case "mt":
+			var ms int64
+			ms, err = strconv.ParseInt(value, 10, 64)
+			result.Time = time.Duration(ms) * time.Millisecond
+		case "time":
+			var ok bool
+			result.Time, ok = parseDuration(value, true)
+			if !ok {
+				return false
+			}
+		case "pv":
+			result.PV = strings.Fields(value)
+		default:
+			if custom != nil {
+				custom(key, value)
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseScore parses pawns "+0.36", "-1.2" or mate "+M12", "-M5", "#-3".
+func parseScore(s string) (common.UciScore, bool) {
+	if s == "" {
+		return common.UciScore{}, false
+	}
+	var negative bool
+	var sign = s[0]
+	if sign == '+' || sign == '-' {
+		negative = sign == '-'
+		s = s[1:]
+	}
+	if strings.HasPrefix(s, "M") || strings.HasPrefix(s, "#") {
+		var mate, err = strconv.Atoi(s[1:])
+		if err != nil {
+			return common.UciScore{}, false
+		}
+		if negative {
+			mate = -mate
+		}
+		return common.UciScore{Mate: mate}, true
+	}
+	var pawns, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return common.UciScore{}, false
+	}
+	if negative {
+		pawns = -pawns
+	}
+	return common.UciScore{Centipawns: int(100 * pawns)}, true
+}
+
+func negateScore(score common.UciScore) common.UciScore {
+	return common.UciScore{Centipawns: -score.Centipawns, Mate: -score.Mate}
+}
+
+// parseDuration parses "56s", "0.312s", "120ms" or plain seconds if allowed.
+func parseDuration(s string, plainSeconds bool) (time.Duration, bool) {
+	if plainSeconds {
+		if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+	}
+	var result, err = time.ParseDuration(s)
+	return result, err == nil
+}
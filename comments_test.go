@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestCommentParsers(t *testing.T) {
+	for _, test := range []struct {
+		format    string
+		comment   string
+		whiteMove bool
+		expected  Comment
+	}{
+		{"cutechess", "+0.36/25 56s", true,
+			Comment{Depth: 25, Time: 56 * time.Second, Score: common.UciScore{Centipawns: 36}}},
+		{"cutechess", "(Qd2) -M5/30 0.5s", false,
+			Comment{Depth: 30, Time: 500 * time.Millisecond, Score: common.UciScore{Mate: -5}, PV: []string{"Qd2"}}},
+		{"fastchess", "+1.50/20 1.234s, n=123456, sd=31, tb=7, pv=e4 e5", true,
+			Comment{Depth: 20, SelDepth: 31, Nodes: 123456, TBHits: 7, Time: 1234 * time.Millisecond,
+				Score: common.UciScore{Centipawns: 150}, PV: []string{"e4", "e5"}}},
+		{"arena", "0.36/25 7", true,
+			Comment{Depth: 25, Time: 7 * time.Second, Score: common.UciScore{Centipawns: 36}}},
+		{"lichess", "[%eval 0.36] [%clk 0:03:00]", false,
+			Comment{Depth: -1, Score: common.UciScore{Centipawns: 36}, AfterMove: true}},
+		{"lichess", "[%eval #-3]", true,
+			Comment{Depth: -1, Score: common.UciScore{Mate: 3}, AfterMove: true}},
+		{"lichess", "[%eval 0.36,25]", true,
+			Comment{Depth: 25, Score: common.UciScore{Centipawns: -36}, AfterMove: true}},
+		{"tcec", "d=28, sd=47, mt=38478, tl=5364, s=43876, n=1683889, pv=Nf3 d5, tb=0, wv=0.36, R50=50", false,
+			Comment{Depth: 28, SelDepth: 47, Nodes: 1683889, Time: 38478 * time.Millisecond,
+				Score: common.UciScore{Centipawns: -36}, PV: []string{"Nf3", "d5"}}},
+		{"banksia", "depth=28, seldepth=47, time=38.5, nodes=1683889, score=+M12", true,
+			Comment{Depth: 28, SelDepth: 47, Nodes: 1683889, Time: 38500 * time.Millisecond,
+				Score: common.UciScore{Mate: 12}}},
+	} {
+		var parser, _ = commentParserByName(test.format)
+		var comment, ok = parser.Parse(test.comment, test.whiteMove)
+		if !ok || fmt.Sprint(comment) != fmt.Sprint(test.expected) {
+			t.Errorf("%v %q: got %+v", test.format, test.comment, comment)
+		}
+		var autoComment, autoOk = (&autoCommentParser{}).Parse(test.comment, test.whiteMove)
+		if !autoOk || fmt.Sprint(autoComment) != fmt.Sprint(test.expected) {
+			t.Errorf("auto %q: got %+v", test.comment, autoComment)
+		}
+	}
+
+	if _, ok := (&CutechessCommentParser{}).Parse("book", true); ok {
+		t.Error("book comment parsed")
+	}
+
+	// formats of one file are detected by the first comment, later comments may use more features
+	for _, comments := range [][]struct {
+		comment  string
+		expected Comment
+	}{
+		{
+			{"+0.00/1 0s", Comment{Depth: 1}},
+			{"+0.36/25 1.2s, n=1000, sd=30", Comment{Depth: 25, SelDepth: 30, Nodes: 1000, Time: 1200 * time.Millisecond,
+				Score: common.UciScore{Centipawns: 36}}},
+		},
+		{
+			{"+0.36/25", Comment{Depth: 25, Score: common.UciScore{Centipawns: 36}}},
+			{"+0.40/26 7", Comment{Depth: 26, Time: 7 * time.Second, Score: common.UciScore{Centipawns: 40}}},
+		},
+		{
+			{"[%eval 0.36]", Comment{Depth: -1, Score: common.UciScore{Centipawns: -36}, AfterMove: true}},
+			{"+0.40/26 1s", Comment{Depth: 26, Time: time.Second, Score: common.UciScore{Centipawns: 40}}},
+		},
+	} {
+		var parser = &autoCommentParser{}
+		for _, test := range comments {
+			var comment, ok = parser.Parse(test.comment, true)
+			if !ok || fmt.Sprint(comment) != fmt.Sprint(test.expected) {
+				t.Errorf("auto %q: got %+v", test.comment, comment)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const progressInterval = 5 * time.Second
+
+var compressedExts = []string{".gz", ".bz2", ".xz", ".zst"}
+
+// compressionExt returns compression extension of file name or empty string for plain files.
+func compressionExt(name string) string {
+	var ext = strings.ToLower(filepath.Ext(name))
+	for _, compressedExt := range compressedExts {
+		if ext == compressedExt {
+			return ext
+		}
+	}
+	return ""
+}
+
+// isPgnFile accepts both plain "game.pgn" and compressed "game.pgn.zst" names.
+func isPgnFile(name string) bool {
+	return inputExt(name) == ".pgn"
+}
+
+// isEpdFile accepts both plain "positions.epd" and compressed "positions.epd.zst" names.
+func isEpdFile(name string) bool {
+	return inputExt(name) == ".epd"
+}
+
+func isInputFile(name string) bool {
+	return isPgnFile(name) || isEpdFile(name)
+}
+
+// inputExt returns lower case extension of file name without compression extension.
+func inputExt(name string) string {
+	var ext = compressionExt(name)
+	if ext != "" {
+		name = name[:len(name)-len(ext)]
+	}
+	return strings.ToLower(filepath.Ext(name))
+}
+
+type pgnFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *pgnFile) Close() error {
+	var result error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		var err = f.closers[i].Close()
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// openPgnFile opens plain or compressed PGN or EPD file for streaming read.
+// Progress is reported by compressed bytes read.
+// Input "-" reads stdin, its compression is detected by magic bytes.
+func openPgnFile(filepath string) (io.ReadCloser, error) {
+	var result = &pgnFile{}
+	var file *os.File
+	if filepath == stdinInput {
+		file = os.Stdin
+	} else {
+		var err error
+		file, err = os.Open(filepath)
+		if err != nil {
+			return nil, err
+		}
+		result.closers = append(result.closers, file)
+	}
+
+	var total int64 = -1
+	if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() {
+		total = stat.Size()
+	}
+	var r = bufio.NewReaderSize(&progressReader{
+		r:        file,
+		progress: newFileProgress(filepath, total),
+	}, 1<<16)
+
+	var ext string
+	if filepath == stdinInput {
+		ext = sniffCompression(r)
+	} else {
+		ext = compressionExt(filepath)
+	}
+
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+		result.Reader = gz
+		result.closers = append(result.closers, gz)
+	case ".bz2":
+		result.Reader = bzip2.NewReader(r)
+	case ".xz":
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+		result.Reader = xzReader
+	case ".zst":
+		zstReader, err := zstd.NewReader(r)
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+		var rc = zstReader.IOReadCloser()
+		result.Reader = rc
+		result.closers = append(result.closers, rc)
+	default:
+		result.Reader = r
+	}
+	return result, nil
+}
+
+var compressionMagics = []struct {
+	ext   string
+	magic string
+}{
+	{".gz", "\x1f\x8b"},
+	{".bz2", "BZh"},
+	{".xz", "\xfd7zXZ\x00"},
+	{".zst", "\x28\xb5\x2f\xfd"},
+}
+
+func sniffCompression(r *bufio.Reader) string {
+	for _, item := range compressionMagics {
+		var header, _ = r.Peek(len(item.magic))
+		if string(header) == item.magic {
+			return item.ext
+		}
+	}
+	return ""
+}
+
+// fileProgress counts bytes read from one file, possibly by several readers.
+type fileProgress struct {
+	name       string
+	total      int64
+	read       int64
+	lastReport int64
+}
+
+func newFileProgress(name string, total int64) *fileProgress {
+	return &fileProgress{
+		name:       name,
+		total:      total,
+		lastReport: time.Now().UnixNano(),
+	}
+}
+
+func (fp *fileProgress) add(n int) {
+	var read = atomic.AddInt64(&fp.read, int64(n))
+	var now = time.Now().UnixNano()
+	var last = atomic.LoadInt64(&fp.lastReport)
+	if now-last >= int64(progressInterval) &&
+		atomic.CompareAndSwapInt64(&fp.lastReport, last, now) {
+		fp.report(read)
+	}
+}
+
+func (fp *fileProgress) report(read int64) {
+	if fp.total <= 0 {
+		log.Printf("%v: read %v\n", filepath.Base(fp.name), formatBytes(read))
+		return
+	}
+	log.Printf("%v: read %v of %v (%.1f%%)\n",
+		filepath.Base(fp.name),
+		formatBytes(read),
+		formatBytes(fp.total),
+		100*float64(read)/float64(fp.total))
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress *fileProgress
+	limit    int64 // bytes added to progress at most, all bytes if 0
+	counted  int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	var n, err = pr.r.Read(p)
+	var count = int64(n)
+	if pr.limit != 0 && pr.counted+count > pr.limit {
+		count = pr.limit - pr.counted
+	}
+	pr.counted += count
+	pr.progress.add(int(count))
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	var div, exp = int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
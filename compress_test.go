@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const compressTestPgn = "[Result \"*\"]\n\n1. e4 e5 *\n"
+
+// compressTestBzip2 is compressTestPgn compressed by bzip2, standard library has no bzip2 writer.
+const compressTestBzip2 = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xbc\x72\x1d\x5f\x00\x00\x08\x5b\x80\x00\x10\x50\x11\x26" +
+	"\x00\x10\x0a\x02\x04\x0e\x00\x20\x00\x31\x4c\x00\x13\x42\x26\x8c\x99\xa8\xf5\x32\x71\x5a\x84\x08\x75\x91" +
+	"\x25\x0e\x59\xd0\xde\xea\xfe\x2e\xe4\x8a\x70\xa1\x21\x78\xe4\x3a\xbe"
+
+func TestCompression(t *testing.T) {
+	for _, test := range []struct {
+		name, ext string
+	}{
+		{"games.pgn", ""},
+		{"games.pgn.zst", ".zst"},
+		{"Games.PGN.GZ", ".gz"},
+		{"games.epd.xz", ".xz"},
+		{"games.pgn.bz2", ".bz2"},
+		{"games.zip", ""},
+	} {
+		if ext := compressionExt(test.name); ext != test.ext {
+			t.Errorf("%v: got extension %q", test.name, ext)
+		}
+	}
+	if !isPgnFile("games.pgn.zst") || isPgnFile("games.zst") || !isEpdFile("positions.EPD.gz") || isInputFile("games.txt") {
+		t.Error("bad input file names")
+	}
+
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var compressed = map[string][]byte{
+		"":     []byte(compressTestPgn),
+		".bz2": []byte(compressTestBzip2),
+	}
+	var buf bytes.Buffer
+	var gz = gzip.NewWriter(&buf)
+	gz.Write([]byte(compressTestPgn))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed[".gz"] = append([]byte(nil), buf.Bytes()...)
+
+	buf.Reset()
+	xzWriter, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xzWriter.Write([]byte(compressTestPgn))
+	if err := xzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed[".xz"] = append([]byte(nil), buf.Bytes()...)
+
+	buf.Reset()
+	zstWriter, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstWriter.Write([]byte(compressTestPgn))
+	if err := zstWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed[".zst"] = append([]byte(nil), buf.Bytes()...)
+
+	var read = func(path string) string {
+		var file, err = openPgnFile(path)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		return string(data)
+	}
+
+	for ext, data := range compressed {
+		var filePath = filepath.Join(dir, "games.pgn"+ext)
+		if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if text := read(filePath); text != compressTestPgn {
+			t.Errorf("%v: got %q", filePath, text)
+		}
+
+		if sniffed := sniffCompression(bufio.NewReader(bytes.NewReader(data))); sniffed != ext {
+			t.Errorf("%v: sniffed %q", ext, sniffed)
+		}
+
+		// stdin compression is detected by magic bytes
+		var stdin = os.Stdin
+		file, err := os.Open(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdin = file
+		var text = read(stdinInput)
+		os.Stdin = stdin
+		file.Close()
+		if text != compressTestPgn {
+			t.Errorf("stdin %v: got %q", ext, text)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.pgn.gz"), []byte(compressTestPgn), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openPgnFile(filepath.Join(dir, "bad.pgn.gz")); err == nil {
+		t.Error("plain file opened as gzip")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// findConfigArg returns value of -config flag before flags are parsed,
+// so that config file provides defaults and other flags override them.
+func findConfigArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		var name = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return name[len("config="):]
+		}
+	}
+	return ""
+}
+
+// loadConfig reads JSON settings file over default settings.
+// Metadata file written next to output is accepted as well to repeat the run.
+func loadConfig(path string, settings *Settings) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var metadata struct {
+		Settings json.RawMessage
+	}
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return fmt.Errorf("config %v: %w", path, err)
+	}
+	if metadata.Settings != nil {
+		data = metadata.Settings
+	}
+	err = json.Unmarshal(data, settings)
+	if err != nil {
+		return fmt.Errorf("config %v: %w", path, err)
+	}
+	return nil
+}
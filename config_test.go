@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	if findConfigArg([]string{"-threads", "2", "--config=a.json"}) != "a.json" ||
+		findConfigArg([]string{"-config", "b.json", "x.pgn"}) != "b.json" ||
+		findConfigArg([]string{"-threads", "2", "x.pgn"}) != "" {
+		t.Fatal("bad config arg")
+	}
+
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var resultPath = filepath.Join(dir, "fengen.txt")
+	var written = Settings{Threads: 3, Analyze: AnalyzeSettings{Filters: FilterSettings{MinDepth: 12, Quiet: true}}}
+	if err := writeMetadata(resultPath, written, nil); err != nil {
+		t.Fatal(err)
+	}
+	var settings = Settings{Readers: 5, Analyze: AnalyzeSettings{Filters: FilterSettings{MinDepth: 10}}}
+	if err := loadConfig(resultPath+metadataSuffix, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Threads != 3 || settings.Analyze.Filters.MinDepth != 12 || !settings.Analyze.Filters.Quiet {
+		t.Fatalf("got %+v", settings)
+	}
+
+	var configPath = filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(configPath, []byte(`{"Analyze": {"Filters": {"MaxPly": 100}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(configPath, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Analyze.Filters.MaxPly != 100 || settings.Analyze.Filters.MinDepth != 12 || settings.Threads != 3 {
+		t.Fatalf("got %+v", settings)
+	}
+
+	// list flags replace lists of config instead of appending to them
+	if err := ioutil.WriteFile(configPath, []byte(`{"Inputs": ["old.pgn"], "Analyze": {"Terminations": ["timeforfeit=drop"]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(configPath, &settings); err != nil {
+		t.Fatal(err)
+	}
+	var flags = flag.NewFlagSet("fengen", flag.ContinueOnError)
+	var inputs = newStringList(&settings.Inputs)
+	flags.Var(inputs, "input", "")
+	flags.Var(newStringList(&settings.Analyze.Terminations), "termination", "")
+	if err := flags.Parse([]string{"-input", "new.pgn", "-input", "a.pgn,b.pgn", "c.pgn"}); err != nil {
+		t.Fatal(err)
+	}
+	inputs.Append(flags.Args()...)
+	if !reflect.DeepEqual(settings.Inputs, []string{"new.pgn", "a.pgn", "b.pgn", "c.pgn"}) ||
+		!reflect.DeepEqual(settings.Analyze.Terminations, []string{"timeforfeit=drop"}) {
+		t.Fatalf("got %v %v", settings.Inputs, settings.Analyze.Terminations)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	PhaseOpening    = "opening"
+	PhaseMiddlegame = "middlegame"
+	PhaseEndgame    = "endgame"
+)
+
+const consistencyStatsPrefix = "consistency: "
+
+// consistencyFilter rejects positions whose score contradicts game result.
+// Expected result is 1/(1+exp(-score/ConsistencyScale)) from white point of view,
+// position is rejected when it differs from game result by more than MaxDisagreement.
+// Rejected positions are counted per game phase.
+type consistencyFilter struct {
+	settings *AnalyzeSettings
+	rejected map[string]int64
+}
+
+func newConsistencyFilter(settings *AnalyzeSettings) *consistencyFilter {
+	return &consistencyFilter{
+		settings: settings,
+		rejected: make(map[string]int64),
+	}
+}
+
+func (f *consistencyFilter) Name() string { return "consistency" }
+
+func (f *consistencyFilter) Accept(c *Candidate) bool {
+	if c.GameResult == NoGameResult {
+		return true
+	}
+	var score = f.settings.centipawns(c.Score)
+	if !c.Position.WhiteMove {
+		score = -score
+	}
+	var expected = 1 / (1 + math.Exp(-float64(score)/f.settings.Filters.ConsistencyScale))
+	if math.Abs(expected-float64(c.GameResult)) <= f.settings.Filters.MaxDisagreement {
+		return true
+	}
+	f.rejected[gamePhase(c.Position)]++
+	return false
+}
+
+func (f *consistencyFilter) Flush(stats *Stats) {
+	for phase, count := range f.rejected {
+		stats.Add(consistencyStatsPrefix+phase, count)
+		delete(f.rejected, phase)
+	}
+}
+
+// gamePhase estimates phase by non-pawn material:
+// minor piece is 1, rook is 2, queen is 4, initial position is 24.
+func gamePhase(p *common.Position) string {
+	var phase = common.PopCount(p.Knights|p.Bishops) +
+		2*common.PopCount(p.Rooks) +
+		4*common.PopCount(p.Queens)
+	if phase >= 22 {
+		return PhaseOpening
+	}
+	if phase <= 8 {
+		return PhaseEndgame
+	}
+	return PhaseMiddlegame
+}
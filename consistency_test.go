@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestConsistency(t *testing.T) {
+	var settings = &AnalyzeSettings{Filters: FilterSettings{MaxDisagreement: 0.8, ConsistencyScale: 400}}
+	var opening, _ = common.NewPositionFromFEN(common.InitialPositionFen)
+	var middlegame, _ = common.NewPositionFromFEN("r4rk1/pp3ppp/2n5/8/8/2N5/PP3PPP/R4RK1 b - - 0 20")
+	var endgame, _ = common.NewPositionFromFEN("4k3/4p3/8/8/8/8/4P3/4K2R b - - 0 40")
+	for _, test := range []struct {
+		position *common.Position
+		phase    string
+	}{
+		{&opening, PhaseOpening},
+		{&middlegame, PhaseMiddlegame},
+		{&endgame, PhaseEndgame},
+	} {
+		if phase := gamePhase(test.position); phase != test.phase {
+			t.Errorf("%v: got %v", test.phase, phase)
+		}
+	}
+
+	var filters = settings.newFilterChain(&AllQuietService{})
+	for _, test := range []struct {
+		position   *common.Position
+		score      int
+		gameResult float32
+		accepted   bool
+	}{
+		{&opening, 0, 0, true},
+		{&opening, 500, 1, true},
+		{&opening, 500, 0, true},
+		{&opening, 1000, 0, false},
+		{&middlegame, 1000, 1, false}, // black to move
+		{&middlegame, 1000, 0, true},
+		{&endgame, -1000, 1, true},
+		{&endgame, -1000, 0, false},
+		{&endgame, -1000, NoGameResult, true},
+	} {
+		var accepted = filters.Accept(&Candidate{
+			Position:   test.position,
+			Evaluated:  true,
+			Score:      common.UciScore{Centipawns: test.score},
+			GameResult: test.gameResult,
+		})
+		if accepted != test.accepted {
+			t.Errorf("%v %v %v: got %v", test.position, test.score, test.gameResult, accepted)
+		}
+	}
+	var stats = NewStats()
+	filters.Flush(stats)
+	var counts = stats.Counts()
+	if counts[filterStatsPrefix+"consistency"] != 3 || counts[consistencyStatsPrefix+PhaseOpening] != 1 ||
+		counts[consistencyStatsPrefix+PhaseMiddlegame] != 1 || counts[consistencyStatsPrefix+PhaseEndgame] != 1 {
+		t.Errorf("got %v", counts)
+	}
+}
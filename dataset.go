@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+// DatasetSeparatorAuto splits dataset line by the first of ";", "|", ",", tab found in line or by space.
+const DatasetSeparatorAuto = "auto"
+
+var datasetSeparators = []string{";", "|", ",", "\t"}
+
+// AnalyzeDatasetLine parses "fen;score;result" line written by fengen or compatible tools,
+// score is centipawns from white point of view.
+// Position rejected by filters is skipped, depth and ply are not known.
+func AnalyzeDatasetLine(filters *FilterChain, settings *AnalyzeSettings, line string) (PositionInfo, bool, error) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, utf8BOM))
+	if line == "" || line[0] == '#' {
+		return PositionInfo{}, false, nil
+	}
+	var fen, sScore, sResult, ok = splitDatasetLine(line, settings.DatasetSeparator)
+	if !ok {
+		return PositionInfo{}, false, fmt.Errorf("parse dataset line failed %v", line)
+	}
+	var score, err = strconv.Atoi(sScore)
+	if err != nil {
+		return PositionInfo{}, false, fmt.Errorf("bad score %v", sScore)
+	}
+	var gameResult, gameResultOk = parseGameResult(sResult)
+	if !gameResultOk {
+		return PositionInfo{}, false, fmt.Errorf("bad game result %v", sResult)
+	}
+	p, castling, err := parseAnyFEN(fen)
+	if err != nil {
+		return PositionInfo{}, false, err
+	}
+
+	// score from side to move point of view
+	if !p.WhiteMove {
+		score = -score
+	}
+	if !filters.Accept(&Candidate{
+		Position:   &p,
+		Castling:   castling,
+		Evaluated:  true,
+		Score:      common.UciScore{Centipawns: score},
+		Depth:      -1,
+		Ply:        -1,
+		GamePly:    -1,
+		GameResult: gameResult,
+	}) {
+		return PositionInfo{}, false, nil
+	}
+	return PositionInfo{
+		position:   p,
+		castling:   castling,
+		score:      score,
+		gameResult: gameResult,
+	}, true, nil
+}
+
+// splitDatasetLine takes score and result from the end of line,
+// so that space separator does not break FEN. Space separator matches any white space.
+func splitDatasetLine(line, separator string) (fen, score, result string, ok bool) {
+	if separator == DatasetSeparatorAuto {
+		separator = " "
+		for _, item := range datasetSeparators {
+			if strings.Contains(line, item) {
+				separator = item
+				break
+			}
+		}
+	}
+	var lastIndex = func(s string) int {
+		if separator == " " {
+			return strings.LastIndexFunc(s, unicode.IsSpace)
+		}
+		return strings.LastIndex(s, separator)
+	}
+	var index = lastIndex(line)
+	if index < 0 {
+		return "", "", "", false
+	}
+	result = strings.TrimSpace(line[index+len(separator):])
+	line = strings.TrimSpace(line[:index])
+	index = lastIndex(line)
+	if index < 0 {
+		return "", "", "", false
+	}
+	score = strings.TrimSpace(line[index+len(separator):])
+	fen = strings.TrimSpace(line[:index])
+	return fen, score, result, true
+}
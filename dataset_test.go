@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDatasetLine(t *testing.T) {
+	for _, test := range []struct {
+		line      string
+		separator string
+		ok        bool
+	}{
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2;-120;0", DatasetSeparatorAuto, true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2 | -120 | 0.0", DatasetSeparatorAuto, true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2,-120,0-1", DatasetSeparatorAuto, true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2 -120 0", DatasetSeparatorAuto, true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2\t-120\t0", " ", true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2 :: -120 :: 0", "::", true},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2;-120", DatasetSeparatorAuto, false},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2;-120;2", DatasetSeparatorAuto, false},
+	} {
+		var settings = &AnalyzeSettings{DatasetSeparator: test.separator}
+		var positionInfo, ok, err = AnalyzeDatasetLine(settings.newFilterChain(&AllQuietService{}), settings, test.line)
+		if ok != test.ok || ok == (err != nil) {
+			t.Errorf("%q: got %v %v", test.line, ok, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var sb strings.Builder
+		writeGame(&sb, []PositionInfo{positionInfo}, CastlingXFEN)
+		if sb.String() != "4k3/8/8/8/8/8/4P3/4K3 b - - 3 2;-120;0\n" {
+			t.Errorf("%q: got %v", test.line, sb.String())
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	DedupFirst   = "first"
+	DedupRandom  = "random"
+	DedupAverage = "average"
+)
+
+const dedupStatsName = "dedup: duplicates"
+
+// DedupSettings configures global deduplication of positions across games by position key.
+// Policy first keeps the first seen position, it is streaming and uses Bloom filter of MemoryMB,
+// so rare unique positions are dropped as false positives and result depends on order of games.
+// Policies random (position chosen by hash of seed) and average (mean score and known result)
+// sort positions in memory and spill sorted runs of MemoryMB to TempDir, which are merged at the end.
+// With Flip colour-flipped positions are identical, position with smaller key is written.
+type DedupSettings struct {
+	Policy   string
+	Flip     bool
+	MemoryMB int
+	TempDir  string
+}
+
+func (s *DedupSettings) enabled() bool {
+	return s.Policy != ""
+}
+
+func (s *DedupSettings) validate() error {
+	switch s.Policy {
+	case "", DedupFirst, DedupRandom, DedupAverage:
+	default:
+		return fmt.Errorf("bad dedup policy %v", s.Policy)
+	}
+	if s.enabled() && s.MemoryMB <= 0 {
+		return fmt.Errorf("bad dedup memory %v", s.MemoryMB)
+	}
+	return nil
+}
+
+// dedupCanonical returns key of position, with flip the position is replaced by colour-flipped one
+// if it has smaller key. Chess960 castling rooks are part of the key.
+func dedupCanonical(item PositionInfo, flip bool) (uint64, PositionInfo) {
+	var key = dedupKey(&item)
+	if !flip {
+		return key, item
+	}
+	var flipped = item
+	var ok bool
+	flipped.position, ok = mirrorPosition(&item.position)
+	if !ok {
+		return key, item
+	}
+	var rooks = item.castling.Rooks
+	flipped.castling.Rooks = [4]int8{rooks[2], rooks[3], rooks[0], rooks[1]}
+	if item.gameResult != NoGameResult {
+		flipped.gameResult = 1 - item.gameResult
+	}
+	if flippedKey := dedupKey(&flipped); flippedKey < key {
+		return flippedKey, flipped
+	}
+	return key, item
+}
+
+// mirrorPosition returns colour-flipped position, false if position can not be flipped.
+func mirrorPosition(p *common.Position) (common.Position, bool) {
+	if !canMirror(p) {
+		return common.Position{}, false
+	}
+	return common.MirrorPosition(p), true
+}
+
+// canMirror reports whether common.MirrorPosition accepts position: each side has one king
+// and king of side not to move is not attacked, which is checked again on flipped board.
+func canMirror(p *common.Position) bool {
+	if common.PopCount(p.Kings&p.White) != 1 || common.PopCount(p.Kings&p.Black) != 1 {
+		return false
+	}
+	var sq = p.KingSq(!p.WhiteMove)
+	var attackers = p.PiecesByColor(p.WhiteMove)
+	var occ = p.White | p.Black
+	return attackers&(common.PawnAttacks(sq, !p.WhiteMove)&p.Pawns|
+		common.KnightAttacks[sq]&p.Knights|
+		common.BishopAttacks(sq, occ)&(p.Bishops|p.Queens)|
+		common.RookAttacks(sq, occ)&(p.Rooks|p.Queens)|
+		common.KingAttacks[sq]&p.Kings) == 0
+}
+
+func dedupKey(item *PositionInfo) uint64 {
+	if !item.castling.Chess960 {
+		return item.position.Key
+	}
+	var rooks uint64
+	for _, rook := range item.castling.Rooks {
+		rooks = rooks<<8 | uint64(uint8(rook))
+	}
+	return item.position.Key ^ splitMix64(rooks)
+}
+
+// dedupPositions removes duplicate positions of games.
+func dedupPositions(
+	ctx context.Context,
+	settings DedupSettings,
+	seed int64,
+	stats *Stats,
+	games <-chan []PositionInfo,
+	unique chan<- []PositionInfo,
+) error {
+	var send = func(positions []PositionInfo) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case unique <- positions:
+			return nil
+		}
+	}
+	if settings.Policy == DedupFirst {
+		return dedupFirst(settings, stats, games, send)
+	}
+	var capacity = max(1, (settings.MemoryMB<<20)/dedupRecordSize)
+	return dedupSorted(settings, capacity, seed, stats, games, send)
+}
+
+func dedupFirst(settings DedupSettings, stats *Stats, games <-chan []PositionInfo,
+	send func(positions []PositionInfo) error) error {
+	var seen = newBloomFilter(uint64(settings.MemoryMB) << 23)
+	var duplicates int64
+	for game := range games {
+		var result = game[:0]
+		for _, item := range game {
+			var key, canonical = dedupCanonical(item, settings.Flip)
+			if seen.Add(key) {
+				result = append(result, canonical)
+			} else {
+				duplicates++
+			}
+		}
+		if len(result) != 0 {
+			if err := send(result); err != nil {
+				return err
+			}
+		}
+	}
+	stats.Add(dedupStatsName, duplicates)
+	return nil
+}
+
+// bloomFilter is set of keys with false positives.
+type bloomFilter struct {
+	bits []uint64
+	size uint64
+}
+
+const bloomHashes = 7
+
+func newBloomFilter(size uint64) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (size+63)/64), size: size}
+}
+
+// Add adds key and reports whether it was not in set.
+func (f *bloomFilter) Add(key uint64) bool {
+	var h1 = splitMix64(key)
+	var h2 = splitMix64(h1) | 1
+	var added = false
+	for i := uint64(0); i < bloomHashes; i++ {
+		var bit = (h1 + i*h2) % f.size
+		var mask = uint64(1) << (bit % 64)
+		if f.bits[bit/64]&mask == 0 {
+			f.bits[bit/64] |= mask
+			added = true
+		}
+	}
+	return added
+}
+
+type dedupRecord struct {
+	key  uint64
+	hash uint64
+	item PositionInfo
+}
+
+// dedupRecordSize is size of dedupRecord in buffer, the record has no pointers to other memory.
+const dedupRecordSize = int(unsafe.Sizeof(dedupRecord{}))
+
+func dedupLess(a, b *dedupRecord) bool {
+	return a.key < b.key || a.key == b.key && a.hash < b.hash
+}
+
+// dedupSorted sorts positions in buffer of capacity records, spilling full buffers to runs.
+// The buffer is allocated once, so memory does not grow above capacity.
+func dedupSorted(settings DedupSettings, capacity int, seed int64, stats *Stats, games <-chan []PositionInfo,
+	send func(positions []PositionInfo) error) error {
+	var buffer = make([]dedupRecord, 0, capacity)
+	var runs []string
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+
+	var sortBuffer = func() {
+		sort.Slice(buffer, func(i, j int) bool { return dedupLess(&buffer[i], &buffer[j]) })
+	}
+	for game := range games {
+		for _, item := range game {
+			var key, canonical = dedupCanonical(item, settings.Flip)
+			buffer = append(buffer, dedupRecord{key: key, hash: balanceHash(seed, &canonical), item: canonical})
+			if len(buffer) >= capacity {
+				sortBuffer()
+				var run, err = writeDedupRun(settings.TempDir, buffer)
+				if err != nil {
+					return err
+				}
+				runs = append(runs, run)
+				buffer = buffer[:0]
+			}
+		}
+	}
+	sortBuffer()
+
+	var merger = &dedupMerger{}
+	if len(runs) == 0 {
+		merger.add(&sliceRecordReader{records: buffer})
+	} else {
+		if len(buffer) != 0 {
+			var run, err = writeDedupRun(settings.TempDir, buffer)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		buffer = nil
+		for _, run := range runs {
+			var file, err = os.Open(run)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			merger.add(&fileRecordReader{scanner: bufio.NewScanner(bufio.NewReaderSize(file, 1<<16))})
+		}
+	}
+
+	var batch = make([]PositionInfo, 0, lineBatchSize)
+	var duplicates int64
+	var err = merger.groups(func(group *dedupGroup) error {
+		duplicates += group.count - 1
+		var item = group.first.item
+		if settings.Policy == DedupAverage {
+			item = group.average()
+		}
+		batch = append(batch, item)
+		if len(batch) == lineBatchSize {
+			if err := send(batch); err != nil {
+				return err
+			}
+			batch = make([]PositionInfo, 0, lineBatchSize)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) != 0 {
+		if err := send(batch); err != nil {
+			return err
+		}
+	}
+	stats.Add(dedupStatsName, duplicates)
+	return nil
+}
+
+// dedupGroup accumulates records of one key, the first record has the smallest hash.
+type dedupGroup struct {
+	first     dedupRecord
+	count     int64
+	scoreSum  float64
+	resultSum float64
+	results   int64
+}
+
+func (g *dedupGroup) add(record *dedupRecord) {
+	if g.count == 0 {
+		g.first = *record
+	}
+	g.count++
+	g.scoreSum += float64(record.item.score)
+	if record.item.gameResult != NoGameResult {
+		g.resultSum += float64(record.item.gameResult)
+		g.results++
+	}
+}
+
+// average returns the first position with mean score and mean of known results.
+func (g *dedupGroup) average() PositionInfo {
+	var result = g.first.item
+	result.score = int(roundHalfAway(g.scoreSum / float64(g.count)))
+	result.gameResult = NoGameResult
+	if g.results != 0 {
+		result.gameResult = float32(g.resultSum / float64(g.results))
+	}
+	return result
+}
+
+func roundHalfAway(x float64) float64 {
+	if x < 0 {
+		return -float64(int64(-x + 0.5))
+	}
+	return float64(int64(x + 0.5))
+}
+
+// writeDedupRun writes sorted records to temporary file as lines "key hash;fen;chess960;score;result".
+// FEN has Shredder castling, so Chess960 castling rooks are restored with chess960 flag.
+func writeDedupRun(dir string, records []dedupRecord) (string, error) {
+	var file, err = ioutil.TempFile(dir, "fengen-dedup-*.txt")
+	if err != nil {
+		return "", err
+	}
+	var w = bufio.NewWriterSize(file, 1<<16)
+	for i := range records {
+		var record = &records[i]
+		var fen = formatFEN(&record.item.position, record.item.castling, CastlingShredder)
+		fmt.Fprintf(w, "%016x %016x;%v;%v;%v;%v\n", record.key, record.hash, fen, record.item.castling.Chess960,
+			record.item.score, record.item.gameResult)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return file.Name(), err
+	}
+	return file.Name(), file.Close()
+}
+
+type recordReader interface {
+	Next() (dedupRecord, bool, error)
+}
+
+type sliceRecordReader struct {
+	records []dedupRecord
+}
+
+func (r *sliceRecordReader) Next() (dedupRecord, bool, error) {
+	if len(r.records) == 0 {
+		return dedupRecord{}, false, nil
+	}
+	var record = r.records[0]
+	r.records = r.records[1:]
+	return record, true, nil
+}
+
+type fileRecordReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *fileRecordReader) Next() (dedupRecord, bool, error) {
+	if !r.scanner.Scan() {
+		return dedupRecord{}, false, r.scanner.Err()
+	}
+	var line = r.scanner.Text()
+	var fields = strings.Split(line, ";")
+	if len(fields) != 5 || len(fields[0]) != 33 {
+		return dedupRecord{}, false, fmt.Errorf("bad dedup record %v", line)
+	}
+	var record dedupRecord
+	var err error
+	if record.key, err = strconv.ParseUint(fields[0][:16], 16, 64); err != nil {
+		return dedupRecord{}, false, err
+	}
+	if record.hash, err = strconv.ParseUint(fields[0][17:], 16, 64); err != nil {
+		return dedupRecord{}, false, err
+	}
+	chess960, err := strconv.ParseBool(fields[2])
+	if err != nil {
+		return dedupRecord{}, false, err
+	}
+	if chess960 {
+		record.item.position, record.item.castling, err = parseFEN960(fields[1])
+	} else {
+		record.item.position, err = common.NewPositionFromFEN(normalizeShredderCastling(fields[1]))
+	}
+	if err != nil {
+		return dedupRecord{}, false, err
+	}
+	if record.item.score, err = strconv.Atoi(fields[3]); err != nil {
+		return dedupRecord{}, false, err
+	}
+	gameResult, err := strconv.ParseFloat(fields[4], 32)
+	if err != nil {
+		return dedupRecord{}, false, err
+	}
+	record.item.gameResult = float32(gameResult)
+	return record, true, nil
+}
+
+// dedupMerger merges sorted record readers.
+type dedupMerger struct {
+	heads   []dedupRecord
+	readers []recordReader
+}
+
+func (m *dedupMerger) Len() int           { return len(m.heads) }
+func (m *dedupMerger) Less(i, j int) bool { return dedupLess(&m.heads[i], &m.heads[j]) }
+func (m *dedupMerger) Swap(i, j int) {
+	m.heads[i], m.heads[j] = m.heads[j], m.heads[i]
+	m.readers[i], m.readers[j] = m.readers[j], m.readers[i]
+}
+func (m *dedupMerger) Push(x interface{}) {}
+func (m *dedupMerger) Pop() interface{} {
+	m.heads = m.heads[:len(m.heads)-1]
+	m.readers = m.readers[:len(m.readers)-1]
+	return nil
+}
+
+func (m *dedupMerger) add(r recordReader) {
+	m.readers = append(m.readers, r)
+	m.heads = append(m.heads, dedupRecord{})
+}
+
+// groups calls f for records of each key in order of keys.
+func (m *dedupMerger) groups(f func(group *dedupGroup) error) error {
+	// read heads
+	for i := len(m.readers) - 1; i >= 0; i-- {
+		var record, ok, err = m.readers[i].Next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.heads[i] = record
+		} else {
+			m.heads = append(m.heads[:i], m.heads[i+1:]...)
+			m.readers = append(m.readers[:i], m.readers[i+1:]...)
+		}
+	}
+	heap.Init(m)
+
+	var group = &dedupGroup{}
+	for len(m.heads) != 0 {
+		var record = m.heads[0]
+		if group.count != 0 && group.first.key != record.key {
+			if err := f(group); err != nil {
+				return err
+			}
+			group = &dedupGroup{}
+		}
+		group.add(&record)
+
+		var next, ok, err = m.readers[0].Next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.heads[0] = next
+			heap.Fix(m, 0)
+		} else {
+			heap.Pop(m)
+		}
+	}
+	if group.count != 0 {
+		return f(group)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestDedup(t *testing.T) {
+	var fens = []string{
+		common.InitialPositionFen,
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+		"4k3/4p3/8/8/8/8/8/4K2R b K - 0 40",
+		"4k2r/8/8/8/8/8/4P3/4K3 w k - 0 40",
+		"bqnbrkrn/pppppppp/8/8/8/8/PPPPPPPP/BQNBRKRN w GEge - 0 1",
+	}
+	var positions []PositionInfo
+	for _, fen := range fens {
+		var p, castling, err = parseAnyFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, PositionInfo{position: p, castling: castling, gameResult: NoGameResult})
+	}
+	// the fourth position is the third one with colours flipped
+	var games [][]PositionInfo
+	for i := 0; i < 20; i++ {
+		var game []PositionInfo
+		for j, item := range positions {
+			item.score = i*10 + j
+			if i%2 == 0 {
+				item.gameResult = 1
+			}
+			if j == 3 && item.gameResult != NoGameResult {
+				item.gameResult = 0
+			}
+			game = append(game, item)
+		}
+		games = append(games, game)
+	}
+
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var run = func(settings DedupSettings, capacity int, games [][]PositionInfo) []string {
+		var input = make(chan []PositionInfo, len(games))
+		for _, game := range games {
+			input <- append([]PositionInfo(nil), game...)
+		}
+		close(input)
+		var output = make(chan []PositionInfo, 10000)
+		var stats = NewStats()
+		var send = func(positions []PositionInfo) error {
+			output <- positions
+			return nil
+		}
+		var err error
+		if settings.Policy == DedupFirst {
+			err = dedupFirst(settings, stats, input, send)
+		} else {
+			err = dedupSorted(settings, capacity, 1, stats, input, send)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		close(output)
+		var result []string
+		for batch := range output {
+			var sb strings.Builder
+			if err := writeGame(&sb, batch, CastlingXFEN); err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")...)
+		}
+		sort.Strings(result)
+		if int(stats.Counts()[dedupStatsName])+len(result) != len(games)*len(positions) {
+			t.Errorf("%+v: got %v duplicates of %v positions", settings, stats.Counts()[dedupStatsName], len(result))
+		}
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 0 {
+			t.Errorf("%+v: %v runs are not removed", settings, len(files))
+		}
+		return result
+	}
+
+	var first = run(DedupSettings{Policy: DedupFirst, MemoryMB: 1}, 0, games)
+	if len(first) != len(positions) || first[0] != "4k2r/8/8/8/8/8/4P3/4K3 w k - 0 1;3;0" {
+		t.Errorf("first: got %v", first)
+	}
+	if flipped := run(DedupSettings{Policy: DedupFirst, Flip: true, MemoryMB: 1}, 0, games); len(flipped) != len(positions)-1 {
+		t.Errorf("first with flip: got %v", flipped)
+	}
+
+	for _, policy := range []string{DedupRandom, DedupAverage} {
+		var settings = DedupSettings{Policy: policy, Flip: true, MemoryMB: 1, TempDir: dir}
+		var result = run(settings, 1000, games)
+		if len(result) != len(positions)-1 {
+			t.Errorf("%v: got %v", policy, result)
+		}
+		if spilled := run(settings, 7, games); !reflect.DeepEqual(spilled, result) {
+			t.Errorf("%v: got %v with runs, %v in memory", policy, spilled, result)
+		}
+		var reversed = make([][]PositionInfo, len(games))
+		for i := range games {
+			reversed[len(games)-1-i] = games[i]
+		}
+		if again := run(settings, 7, reversed); !reflect.DeepEqual(again, result) {
+			t.Errorf("%v: result depends on order of games", policy)
+		}
+	}
+
+	// scores 0..190 step 10 average to 95, half of games are won and the rest have no result
+	var average = run(DedupSettings{Policy: DedupAverage, MemoryMB: 1, TempDir: dir}, 7, games)
+	if !containsString(average, common.InitialPositionFen+";95;1") {
+		t.Errorf("average: got %v", average)
+	}
+
+	for _, bad := range []DedupSettings{
+		{Policy: "last", MemoryMB: 1},
+		{Policy: DedupRandom},
+	} {
+		if bad.validate() == nil {
+			t.Errorf("%+v: validated", bad)
+		}
+	}
+}
+
+func TestDedupRun(t *testing.T) {
+	var records []dedupRecord
+	for _, fen := range []string{
+		"4k2r/8/8/8/8/8/4P3/4K3 w k - 0 40",
+		// Chess960 positions with rooks in the corners are not standard
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rkrnbbqn/pppppppp/8/8/8/8/PPPPPPPP/RKRNBBQN w Cc - 0 1",
+	} {
+		var p, castling, err = parseFEN960(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) == 0 {
+			castling = Castling{}
+		}
+		var item = PositionInfo{position: p, castling: castling, score: -25, gameResult: 0.5}
+		records = append(records, dedupRecord{key: dedupKey(&item), hash: uint64(len(records)), item: item})
+	}
+
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := writeDedupRun(dir, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var reader = &fileRecordReader{scanner: bufio.NewScanner(file)}
+	for _, expected := range records {
+		var record, ok, err = reader.Next()
+		if err != nil || !ok {
+			t.Fatalf("%v: not read: %v", formatFEN(&expected.item.position, expected.item.castling, CastlingShredder), err)
+		}
+		if !reflect.DeepEqual(record, expected) {
+			t.Errorf("got %+v, expected %+v", record, expected)
+		}
+	}
+	if _, ok, err := reader.Next(); ok || err != nil {
+		t.Errorf("got record after the last one: %v", err)
+	}
+}
+
+func TestMirrorPosition(t *testing.T) {
+	var p, err = common.NewPositionFromFEN("4k3/8/8/8/8/8/8/4R1K1 b - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mirrored, ok := mirrorPosition(&p); !ok || mirrored.String() != "4r1k1/8/8/8/8/8/8/4K3 w - - 0 1" {
+		t.Errorf("got %v, %v", mirrored.String(), ok)
+	}
+	// king of side not to move is in check
+	p.WhiteMove = true
+	if _, ok := mirrorPosition(&p); ok {
+		t.Error("illegal position mirrored")
+	}
+	p, _ = common.NewPositionFromFEN("4k3/8/8/8/8/8/8/4R1K1 b - - 0 1")
+	p.Kings &^= p.Black
+	if _, ok := mirrorPosition(&p); ok {
+		t.Error("position without king mirrored")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+)
+
+const duplicateStatsPrefix = "duplicate games: "
+
+// defaultDuplicateTags identify game together with its main line moves and FEN tag.
+// Event and Site are not used as archives name the same event differently.
+var defaultDuplicateTags = []string{"White", "Black", "Date", "Result"}
+
+// DuplicateGameSettings configures detection of the same game in several inputs,
+// e.g. monthly and full archives of a rating list. Repeats are skipped before analysis.
+type DuplicateGameSettings struct {
+	Enabled bool
+	// Tags identify game with its moves, defaultDuplicateTags if empty.
+	Tags []string
+}
+
+// gameDeduplicator remembers hashes of games read so far.
+// The first copy of game is kept, so with parallel readers the kept copy may differ between runs.
+type gameDeduplicator struct {
+	tags       []string
+	seen       map[uint64]int // hash of game to index of its file
+	files      []string
+	fileIndex  map[string]int
+	duplicates map[[2]int]int64 // file of duplicate and file of the first copy to count
+}
+
+func newGameDeduplicator(settings DuplicateGameSettings) *gameDeduplicator {
+	var tags = settings.Tags
+	if len(tags) == 0 {
+		tags = defaultDuplicateTags
+	}
+	return &gameDeduplicator{
+		tags:       tags,
+		seen:       make(map[uint64]int),
+		fileIndex:  make(map[string]int),
+		duplicates: make(map[[2]int]int64),
+	}
+}
+
+// Add reports whether game was not seen before. Games with bad tags are passed to analysis,
+// which reports them.
+func (d *gameDeduplicator) Add(pgn *Pgn) bool {
+	var hash, ok = gameHash(pgn.Text, d.tags)
+	if !ok {
+		return true
+	}
+	var file, found = d.fileIndex[pgn.File]
+	if !found {
+		file = len(d.files)
+		d.files = append(d.files, pgn.File)
+		d.fileIndex[pgn.File] = file
+	}
+	if first, found := d.seen[hash]; found {
+		d.duplicates[[2]int{file, first}]++
+		return false
+	}
+	d.seen[hash] = file
+	return true
+}
+
+// gameHash hashes identifying tags, FEN tag and main line moves.
+// Move numbers, comments, annotations, variations and check signs do not change hash.
+func gameHash(pgn string, tags []string) (uint64, bool) {
+	var tagSection, movetext, movetextLine, err = parseTagSection(pgn)
+	if err != nil || len(tagSection) == 0 {
+		return 0, false
+	}
+	var hasher = fnv.New64a()
+	var writeTag = func(key string) {
+		var value, _ = tagValue(tagSection, key)
+		hasher.Write([]byte(key))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(strings.TrimSpace(value)))
+		hasher.Write([]byte{0})
+	}
+	for _, key := range tags {
+		writeTag(key)
+	}
+	writeTag("FEN")
+	var lexer = NewPgnLexer(movetext, movetextLine)
+	var depth = 0
+	for {
+		var token, ok = lexer.Next()
+		if !ok {
+			break
+		}
+		switch token.Kind {
+		case TokenVariationStart:
+			depth++
+		case TokenVariationEnd:
+			depth--
+		case TokenMove:
+			if depth == 0 {
+				hasher.Write([]byte(normalizeMove(token.Value)))
+				hasher.Write([]byte{' '})
+			}
+		}
+	}
+	return hasher.Sum64(), true
+}
+
+// normalizeMove removes check signs, annotations and promotion sign of SAN move.
+func normalizeMove(san string) string {
+	san = strings.TrimRight(normalizeCastling(san), "+#!?")
+	return strings.Replace(san, "=", "", 1)
+}
+
+// Report logs and counts duplicates by files.
+func (d *gameDeduplicator) Report(stats *Stats) {
+	var pairs = make([][2]int, 0, len(d.duplicates))
+	var total int64
+	for pair, count := range d.duplicates {
+		pairs = append(pairs, pair)
+		total += count
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0] || pairs[i][0] == pairs[j][0] && pairs[i][1] < pairs[j][1]
+	})
+	log.Printf("Duplicate games: %v of %v", total, int64(len(d.seen))+total)
+	for _, pair := range pairs {
+		var count = d.duplicates[pair]
+		log.Printf("%v: %v duplicates of games from %v", d.files[pair[0]], count, d.files[pair[1]])
+		stats.Add(duplicateStatsPrefix+d.files[pair[0]], count)
+	}
+}
+
+// dedupGames passes games not seen before.
+func dedupGames(
+	ctx context.Context,
+	settings DuplicateGameSettings,
+	stats *Stats,
+	pgns <-chan Pgn,
+	unique chan<- Pgn,
+) error {
+	var deduplicator = newGameDeduplicator(settings)
+	for pgn := range pgns {
+		if !deduplicator.Add(&pgn) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case unique <- pgn:
+		}
+	}
+	deduplicator.Report(stats)
+	return nil
+}
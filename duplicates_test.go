@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDuplicateGames(t *testing.T) {
+	const game = "[Event \"CCRL 40/15\"]\n[White \"A\"]\n[Black \"B\"]\n[Date \"2024.01.02\"]\n[Result \"1-0\"]\n\n" +
+		"1. e4 {+0.30/20 1.5s} e5 2. Nf3 (2. f4 exf4) Nc6 3. Bb5 a6 4. Bxc6 dxc6 5. O-O f6 6. d4 Bg4 7. dxe5 Qxd1 8. Rxd1 fxe5 9. Rd8+ Kxd8 1-0\n"
+	var pgns = []Pgn{
+		{Text: game, File: "monthly.pgn"},
+		// the same game with other event, comments, annotations and move numbers
+		{Text: "[Event \"CCRL 40/15 full\"]\n[White \"A\"]\n[Black \"B\"]\n[Date \"2024.01.02\"]\n[Result \"1-0\"]\n\n" +
+			"1.e4 e5 2.Nf3! Nc6 $1 3.Bb5 a6 4.Bxc6 dxc6 5.0-0 f6 6.d4 Bg4 7.dxe5 Qxd1 8.Rxd1 fxe5 9.Rd8 Kxd8 1-0\n", File: "full.pgn"},
+		{Text: game, File: "full.pgn"},
+		{Text: game, File: "monthly.pgn"},
+		// other players, moves or start position
+		{Text: "[White \"C\"]\n[Black \"B\"]\n[Date \"2024.01.02\"]\n[Result \"1-0\"]\n\n1. e4 e5 2. Nf3 Nc6 1-0\n", File: "full.pgn"},
+		{Text: "[White \"A\"]\n[Black \"B\"]\n[Date \"2024.01.02\"]\n[Result \"1-0\"]\n\n1. e4 e5 2. Nf3 Nc6 1-0\n", File: "full.pgn"},
+		{Text: "[White \"A\"]\n[Black \"B\"]\n[Date \"2024.01.02\"]\n[Result \"1-0\"]\n\n1. e4 e5 2. Nf3 Nf6 1-0\n", File: "full.pgn"},
+		{Text: "[White \"A\"]\n[Black \"B\"]\n[Date \"2024.01.02\"]\n[Result \"1-0\"]\n[FEN \"rnbqkbnr/pppppppp/8/8/8/4P3/PPPP1PPP/RNBQKBNR b KQkq - 0 1\"]\n\n1. e4 e5 2. Nf3 Nf6 1-0\n", File: "full.pgn"},
+		// bad tags are passed to analysis
+		{Text: "1. e4 e5 1-0\n", File: "full.pgn"},
+		{Text: "1. e4 e5 1-0\n", File: "full.pgn"},
+	}
+
+	var input = make(chan Pgn, len(pgns))
+	for _, pgn := range pgns {
+		input <- pgn
+	}
+	close(input)
+	var output = make(chan Pgn, len(pgns))
+	var stats = NewStats()
+	if err := dedupGames(context.Background(), DuplicateGameSettings{Enabled: true}, stats, input, output); err != nil {
+		t.Fatal(err)
+	}
+	close(output)
+	var unique []Pgn
+	for pgn := range output {
+		unique = append(unique, pgn)
+	}
+	if len(unique) != len(pgns)-3 || unique[0].File != "monthly.pgn" {
+		t.Errorf("got %v unique games", len(unique))
+	}
+	var counts = stats.Counts()
+	if counts[duplicateStatsPrefix+"full.pgn"] != 2 || counts[duplicateStatsPrefix+"monthly.pgn"] != 1 {
+		t.Errorf("got %v", counts)
+	}
+
+	// with Event tag the first two games differ
+	var deduplicator = newGameDeduplicator(DuplicateGameSettings{Tags: []string{"Event", "White", "Black"}})
+	if !deduplicator.Add(&pgns[0]) || !deduplicator.Add(&pgns[1]) || deduplicator.Add(&pgns[2]) {
+		t.Error("Event tag is not used")
+	}
+}
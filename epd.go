@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+// Epd is position with operations, e.g.
+// rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - ce 35; c9 "1/2-1/2";
+// Quotes are removed from string operands.
+type Epd struct {
+	Position   common.Position
+	Castling   Castling
+	Operations map[string]string
+}
+
+// AnalyzeEpd takes score from ce (centipawns from side to move point of view)
+// or dm (moves to mate) operation, depth from acd, engine move from bm and game result from c9 operation.
+// Position without score or rejected by filters is skipped.
+func AnalyzeEpd(filters *FilterChain, settings *AnalyzeSettings, line string) (PositionInfo, bool, error) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, utf8BOM))
+	if line == "" || line[0] == '#' {
+		return PositionInfo{}, false, nil
+	}
+	var epd, err = ParseEpd(line)
+	if err != nil {
+		return PositionInfo{}, false, err
+	}
+
+	var score common.UciScore
+	if ce, found := epd.Operations["ce"]; found {
+		score.Centipawns, err = strconv.Atoi(ce)
+		if err != nil {
+			return PositionInfo{}, false, fmt.Errorf("bad ce %v", ce)
+		}
+	} else if dm, found := epd.Operations["dm"]; found {
+		score.Mate, err = strconv.Atoi(dm)
+		if err != nil || score.Mate == 0 {
+			return PositionInfo{}, false, fmt.Errorf("bad dm %v", dm)
+		}
+	} else {
+		return PositionInfo{}, false, nil
+	}
+
+	var gameResult = NoGameResult
+	if c9, found := epd.Operations["c9"]; found {
+		var ok bool
+		gameResult, ok = parseGameResult(c9)
+		if !ok {
+			return PositionInfo{}, false, fmt.Errorf("bad c9 %v", c9)
+		}
+	}
+
+	var depth = -1
+	if acd, found := epd.Operations["acd"]; found {
+		depth, err = strconv.Atoi(acd)
+		if err != nil {
+			return PositionInfo{}, false, fmt.Errorf("bad acd %v", acd)
+		}
+	}
+
+	var bestMove string
+	if bm := strings.Fields(epd.Operations["bm"]); len(bm) != 0 {
+		bestMove = bm[0]
+	}
+
+	if !filters.Accept(&Candidate{
+		Position:   &epd.Position,
+		Castling:   epd.Castling,
+		Evaluated:  true,
+		Score:      score,
+		Depth:      depth,
+		Ply:        -1,
+		GamePly:    -1,
+		PVMove:     bestMove,
+		GameResult: gameResult,
+	}) {
+		return PositionInfo{}, false, nil
+	}
+	return PositionInfo{
+		position:   epd.Position,
+		castling:   epd.Castling,
+		score:      settings.centipawns(score),
+		gameResult: gameResult,
+	}, true, nil
+}
+
+// ParseEpd parses four position fields followed by operations "opcode operands;".
+// Halfmove clock and fullmove number may follow position fields as in FEN
+// or be given by hmvc and fmvn operations.
+func ParseEpd(line string) (Epd, error) {
+	var fields = strings.Fields(line)
+	if len(fields) < 4 {
+		return Epd{}, fmt.Errorf("parse epd failed %v", line)
+	}
+	var rest = line
+	for i := 0; i < 4; i++ {
+		rest = strings.TrimSpace(rest)
+		rest = rest[len(fields[i]):]
+	}
+	var clocks = []string{"0", "1"}
+	for i := 4; i < 6 && i < len(fields) && isDigits(fields[i]); i++ {
+		clocks[i-4] = fields[i]
+		rest = strings.TrimSpace(rest)[len(fields[i]):]
+	}
+
+	var operations, err = parseEpdOperations(rest)
+	if err != nil {
+		return Epd{}, err
+	}
+	if hmvc, found := operations["hmvc"]; found {
+		clocks[0] = hmvc
+	}
+	if fmvn, found := operations["fmvn"]; found {
+		clocks[1] = fmvn
+	}
+
+	var fen = strings.Join(append(fields[:4:4], clocks...), " ")
+	p, castling, err := parseAnyFEN(fen)
+	if err != nil {
+		return Epd{}, err
+	}
+	return Epd{Position: p, Castling: castling, Operations: operations}, nil
+}
+
+// parseEpdOperations parses "opcode operand ...;" list, semicolon inside quoted string is not a separator.
+func parseEpdOperations(s string) (map[string]string, error) {
+	var result = make(map[string]string)
+	var inQuotes bool
+	var start int
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			if s[i] == '"' {
+				inQuotes = !inQuotes
+			}
+			if s[i] != ';' || inQuotes {
+				continue
+			}
+		} else if inQuotes {
+			return nil, fmt.Errorf("unterminated string %v", s)
+		}
+		var operation = strings.TrimSpace(s[start:i])
+		start = i + 1
+		if operation == "" {
+			continue
+		}
+		var opcode, operand = operation, ""
+		if index := strings.IndexAny(operation, " \t"); index >= 0 {
+			opcode, operand = operation[:index], strings.TrimSpace(operation[index+1:])
+		}
+		if len(operand) >= 2 && operand[0] == '"' && operand[len(operand)-1] == '"' {
+			operand = operand[1 : len(operand)-1]
+		}
+		result[opcode] = operand
+	}
+	return result, nil
+}
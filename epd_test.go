@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEpd(t *testing.T) {
+	var settings = &AnalyzeSettings{Mate: MateKeep, MateScore: 3000, Filters: FilterSettings{Check: true}}
+	for _, test := range []struct {
+		line     string
+		ok       bool
+		expected string
+	}{
+		{`rnbqkbnr/pp1ppppp/8/2p5/4P3/8/PPPP1PPP/RNBQKBNR w KQkq c6 ce 35; c9 "1/2-1/2"; id "x;y";`, true,
+			"rnbqkbnr/pp1ppppp/8/2p5/4P3/8/PPPP1PPP/RNBQKBNR w KQkq c6 0 1;35;0.5"},
+		{`4k3/8/8/8/8/8/4P3/4K3 b - - 3 40 ce 120; c9 "0-1";`, true,
+			"4k3/8/8/8/8/8/4P3/4K3 b - - 3 2;-120;0"},
+		{`4k3/8/8/8/8/8/4P3/4K3 w - - ce -20; hmvc 7; fmvn 30;`, true,
+			"4k3/8/8/8/8/8/4P3/4K3 w - - 7 4;-20;*"},
+		{`6k1/5ppp/8/8/8/8/5PPP/R5K1 w - - dm 1; c9 "1-0";`, true,
+			"6k1/5ppp/8/8/8/8/5PPP/R5K1 w - - 0 1;3000;1"},
+		{`bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - ce 10; c9 1;`, true,
+			"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w KQkq - 0 1;10;1"},
+		{`4k3/8/8/8/8/8/4P3/4K3 w - - c9 "1-0";`, false, ""},
+		{`4k3/8/8/8/8/8/4r3/4K3 w - - ce 0;`, false, ""},
+	} {
+		var positionInfo, ok, err = AnalyzeEpd(settings.newFilterChain(&AllQuietService{}), settings, test.line)
+		if err != nil || ok != test.ok {
+			t.Errorf("%v: got %v %v", test.line, ok, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var sb strings.Builder
+		writeGame(&sb, []PositionInfo{positionInfo}, CastlingXFEN)
+		if sb.String() != test.expected+"\n" {
+			t.Errorf("%v: got %v", test.line, sb.String())
+		}
+	}
+
+	if _, _, err := AnalyzeEpd(settings.newFilterChain(&AllQuietService{}), settings, `4k3/8/8/8/8/8/4P3/4K3 w - - ce x;`); err == nil {
+		t.Error("bad ce parsed")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	ReasonBadTags         = "bad tags"
+	ReasonBadFEN          = "bad FEN tag"
+	ReasonBadSAN          = "bad SAN"
+	ReasonBadResult       = "bad result"
+	ReasonNotAdjudicated  = "not adjudicated"
+	ReasonOther           = "other"
+	errorStatsPrefix      = "error: "
+	strictMinGames        = 1000
+	quarantineHeaderStart = "% fengen: "
+)
+
+// GameError is the reason why game is rejected.
+// Line is 1-based line within game text, 0 if unknown.
+type GameError struct {
+	Reason string
+	Detail string
+	Line   int
+}
+
+func (e *GameError) Error() string {
+	if e.Detail == "" {
+		return e.Reason
+	}
+	return e.Reason + ": " + e.Detail
+}
+
+func newGameError(reason string, line int, format string, args ...interface{}) *GameError {
+	return &GameError{Reason: reason, Line: line, Detail: fmt.Sprintf(format, args...)}
+}
+
+// ErrorReporter counts games and rejected games by reason, logs errors with source location
+// and writes rejected games to quarantine PGN file.
+// In strict mode error rate above MaxErrorRate aborts the run.
+type ErrorReporter struct {
+	Strict       bool
+	MaxErrorRate float64
+	stats        *Stats
+	mu           sync.Mutex
+	games        int64
+	errors       int64
+	quarantine   *os.File
+}
+
+// NewErrorReporter creates reporter, quarantinePath may be empty.
+func NewErrorReporter(quarantinePath string, stats *Stats) (*ErrorReporter, error) {
+	var result = &ErrorReporter{stats: stats}
+	if quarantinePath != "" {
+		file, err := os.Create(quarantinePath)
+		if err != nil {
+			return nil, err
+		}
+		result.quarantine = file
+	}
+	return result, nil
+}
+
+func (r *ErrorReporter) Close() error {
+	if r.quarantine == nil {
+		return nil
+	}
+	return r.quarantine.Close()
+}
+
+// Game counts analyzed game.
+func (r *ErrorReporter) Game() {
+	r.mu.Lock()
+	r.games++
+	r.mu.Unlock()
+}
+
+// Report registers rejected game. Error is returned in strict mode if error rate is exceeded.
+func (r *ErrorReporter) Report(pgn *Pgn, err error) error {
+	var gameErr, ok = err.(*GameError)
+	if !ok {
+		gameErr = &GameError{Reason: ReasonOther, Detail: err.Error()}
+	}
+	var location = pgn.Location()
+	if pgn.Line != 0 && gameErr.Line != 0 {
+		location = fmt.Sprintf("%v:%v", pgn.File, pgn.Line+gameErr.Line-1)
+	}
+	log.Printf("Game error %v game %v: %v", location, pgn.Index, gameErr)
+	r.stats.Add(errorStatsPrefix+gameErr.Reason, 1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+	if r.quarantine != nil {
+		var text = strings.TrimRight(pgn.Text, "\r\n")
+		var _, err = fmt.Fprintf(r.quarantine, "%v%v game %v: %v\n%v\n\n", quarantineHeaderStart, location, pgn.Index, gameErr, text)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Strict && (r.games >= strictMinGames || r.MaxErrorRate == 0) {
+		return r.checkLocked()
+	}
+	return nil
+}
+
+// Check returns error in strict mode if error rate is exceeded.
+func (r *ErrorReporter) Check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.Strict {
+		return nil
+	}
+	return r.checkLocked()
+}
+
+func (r *ErrorReporter) checkLocked() error {
+	if r.games == 0 || float64(r.errors) <= r.MaxErrorRate*float64(r.games) {
+		return nil
+	}
+	return fmt.Errorf("strict mode: %v of %v games rejected, error rate %.4f exceeds %v",
+		r.errors, r.games, float64(r.errors)/float64(r.games), r.MaxErrorRate)
+}
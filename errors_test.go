@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGameErrors(t *testing.T) {
+	for _, test := range []struct {
+		pgn    string
+		reason string
+		line   int
+	}{
+		{"[Event \"x\"]\n[Result \"1-0\"]\n\n1. e4 e5\n2. Nf3 Nf6 3. Ke3 Nc6 1-0", ReasonBadSAN, 5},
+		{"[Event \"x\"]\n[Result \"1-0\n\n1. e4 1-0", ReasonBadTags, 2},
+		{"[Result \"1-0\"]\n[FEN \"garbage\"]\n\n1. e4 1-0", ReasonBadFEN, 0},
+		{"[Result \"draw\"]\n\n1. e4 1/2-1/2", ReasonBadResult, 0},
+		{"[Result \"*\"]\n\n1. e4 *", ReasonBadResult, 0},
+	} {
+		var _, err = AnalyzeGame(&AllQuietService{}, &AnalyzeSettings{}, nil, test.pgn, nil)
+		var gameErr, ok = err.(*GameError)
+		if !ok || gameErr.Reason != test.reason || gameErr.Line != test.line {
+			t.Errorf("%q: got %#v", test.pgn, err)
+		}
+	}
+	// illegal move in variation is not an error
+	if game, err := ParseGame("[Result \"*\"]\n\n1. e4 (1. Ke3) e5 *", nil); err != nil || game.BadMove != nil {
+		t.Error(err, game.BadMove)
+	}
+	// moves before illegal one are kept
+	const truncated = "[Result \"1-0\"]\n\n1. e4 {+0.30/20} d5 {-0.20/20} 2. e5 {+0.40/20} f5 {-0.30/20} " +
+		"3. exf6 e.p. {+0.50/20} gxf6 {-0.40/20} 4. Ke3 {+0.10/20} Nc6 1-0"
+	if game, err := ParseGame(truncated, nil); err != nil || len(game.Items) != 6 ||
+		game.BadMove == nil || game.BadMove.Detail != "ply 7: Ke3" {
+		t.Errorf("got %v items, %v, %v", len(game.Items), game.BadMove, err)
+	}
+	if positions, err := AnalyzeGame(&AllQuietService{}, &AnalyzeSettings{}, nil, truncated, nil); len(positions) != 6 ||
+		err == nil || err.(*GameError).Reason != ReasonBadSAN {
+		t.Errorf("got %v positions, %v", len(positions), err)
+	}
+
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var stats = NewStats()
+	reporter, err := NewErrorReporter(filepath.Join(dir, "quarantine.pgn"), stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter.Strict = true
+	reporter.MaxErrorRate = 0.4
+	for i, text := range []string{"[Result \"1-0\"]\n\n1. e4 e5 2. Ke3 1-0\n\n", "[Result \"1-0\"]\n\n1. e4 1-0", "[Result \"0-1\"]\n\n1. e4 0-1"} {
+		reporter.Game()
+		var pgn = Pgn{Text: text, File: "a.pgn", Index: i + 1, Line: 10*i + 1}
+		if _, err := AnalyzeGame(&AllQuietService{}, &AnalyzeSettings{}, nil, text, stats); err != nil {
+			if err := reporter.Report(&pgn, err); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := reporter.Check(); err != nil {
+		t.Error(err)
+	}
+	reporter.Game()
+	reporter.Report(&Pgn{Text: "1. e4", File: "a.pgn", Index: 4}, newGameError(ReasonBadTags, 1, "empty tags"))
+	if err := reporter.Check(); err == nil {
+		t.Error("strict mode accepted error rate 0.5")
+	}
+	reporter.Close()
+
+	quarantine, err := ioutil.ReadFile(filepath.Join(dir, "quarantine.pgn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = "% fengen: a.pgn:3 game 1: bad SAN: ply 3: Ke3\n[Result \"1-0\"]\n\n1. e4 e5 2. Ke3 1-0\n\n" +
+		"% fengen: a.pgn@0 game 4: bad tags: empty tags\n1. e4\n\n"
+	if string(quarantine) != expected {
+		t.Errorf("got quarantine\n%v", string(quarantine))
+	}
+	if counts := stats.Counts(); counts[errorStatsPrefix+ReasonBadSAN] != 1 || counts[errorStatsPrefix+ReasonBadTags] != 1 {
+		t.Errorf("got %v", counts)
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+// Expression language of -game-filter and -position-filter, e.g.
+//   WhiteElo > 3200 && ply >= 16 && abs(score) < 800 && pieces <= 12
+// Values are numbers, strings and booleans. Operators by priority:
+//   || ; && ; ! ; == != < <= > >= =~ !~ ; + - ; * / % ; unary -
+// =~ and !~ match string with regular expression literal. Functions are abs(x), min(x, y), max(x, y).
+// Identifiers starting with upper case letter are game tags, compared as numbers
+// with numbers and as strings with strings (two tags are compared as numbers by < <= > >=).
+// Missing or not numeric tag is NaN as number, so any comparison with it is false.
+// EPD and dataset positions have no tags.
+
+type exprType int
+
+const (
+	exprNumber exprType = iota
+	exprString
+	exprBool
+	exprTag // string convertible to number
+)
+
+func (t exprType) String() string {
+	switch t {
+	case exprNumber:
+		return "number"
+	case exprString:
+		return "string"
+	case exprBool:
+		return "boolean"
+	}
+	return "tag"
+}
+
+// exprEnv is the game or the position filter expression is evaluated for.
+type exprEnv struct {
+	game        *Game
+	tags        []Tag
+	gameResult  float32
+	termination string
+	candidate   *Candidate
+	// settings convert mate scores of candidate to centipawns
+	settings *AnalyzeSettings
+}
+
+// exprNode is compiled expression, function of its type is set.
+type exprNode struct {
+	typ     exprType
+	num     func(env *exprEnv) float64
+	str     func(env *exprEnv) string
+	boolean func(env *exprEnv) bool
+}
+
+func numberVar(f func(env *exprEnv) float64) exprNode {
+	return exprNode{typ: exprNumber, num: f}
+}
+
+func stringVar(f func(env *exprEnv) string) exprNode {
+	return exprNode{typ: exprString, str: f}
+}
+
+func boolVar(f func(env *exprEnv) bool) exprNode {
+	return exprNode{typ: exprBool, boolean: f}
+}
+
+func resultVar(env *exprEnv) float64 {
+	if env.gameResult == NoGameResult {
+		return math.NaN()
+	}
+	return float64(env.gameResult)
+}
+
+var gameExprVars = map[string]exprNode{
+	"plies":       numberVar(func(env *exprEnv) float64 { return float64(len(env.game.Items)) }),
+	"result":      numberVar(resultVar),
+	"termination": stringVar(func(env *exprEnv) string { return env.termination }),
+}
+
+func pieceCountVar(pieces func(p *common.Position) uint64) exprNode {
+	return numberVar(func(env *exprEnv) float64 {
+		return float64(common.PopCount(pieces(env.candidate.Position)))
+	})
+}
+
+var positionExprVars = map[string]exprNode{
+	"ply":         numberVar(func(env *exprEnv) float64 { return float64(env.candidate.Ply) }),
+	"gameply":     numberVar(func(env *exprEnv) float64 { return float64(env.candidate.GamePly) }),
+	"score":       numberVar(func(env *exprEnv) float64 { return float64(env.settings.centipawns(env.candidate.Score)) }),
+	"mate":        numberVar(func(env *exprEnv) float64 { return float64(env.candidate.Score.Mate) }),
+	"depth":       numberVar(func(env *exprEnv) float64 { return float64(env.candidate.Depth) }),
+	"white":       boolVar(func(env *exprEnv) bool { return env.candidate.Position.WhiteMove }),
+	"halfmove":    numberVar(func(env *exprEnv) float64 { return float64(env.candidate.Position.Rule50) }),
+	"check":       boolVar(func(env *exprEnv) bool { return env.candidate.Position.IsCheck() }),
+	"phase":       stringVar(func(env *exprEnv) string { return gamePhase(env.candidate.Position) }),
+	"repetitions": numberVar(func(env *exprEnv) float64 { return float64(env.candidate.Repetitions) }),
+	"result": numberVar(func(env *exprEnv) float64 {
+		return resultVar(&exprEnv{gameResult: env.candidate.GameResult})
+	}),
+	"pieces":  pieceCountVar(func(p *common.Position) uint64 { return p.White | p.Black }),
+	"pawns":   pieceCountVar(func(p *common.Position) uint64 { return p.Pawns }),
+	"knights": pieceCountVar(func(p *common.Position) uint64 { return p.Knights }),
+	"bishops": pieceCountVar(func(p *common.Position) uint64 { return p.Bishops }),
+	"rooks":   pieceCountVar(func(p *common.Position) uint64 { return p.Rooks }),
+	"queens":  pieceCountVar(func(p *common.Position) uint64 { return p.Queens }),
+}
+
+// Expr is compiled boolean expression.
+type Expr struct {
+	source string
+	eval   func(env *exprEnv) bool
+}
+
+func (e *Expr) String() string { return e.source }
+
+// compileExpressions compiles GameFilter and PositionFilter, it is called once before games are analyzed.
+func (settings *AnalyzeSettings) compileExpressions() error {
+	var err error
+	if settings.GameFilter != "" {
+		if settings.gameFilter, err = CompileGameExpr(settings.GameFilter); err != nil {
+			return fmt.Errorf("game filter: %w", err)
+		}
+	}
+	if settings.PositionFilter != "" {
+		if settings.positionFilter, err = CompilePositionExpr(settings.PositionFilter); err != nil {
+			return fmt.Errorf("position filter: %w", err)
+		}
+	}
+	return nil
+}
+
+// CompileGameExpr compiles expression of game filter.
+func CompileGameExpr(source string) (*Expr, error) {
+	return compileExpr(source, gameExprVars)
+}
+
+// CompilePositionExpr compiles expression of position filter.
+func CompilePositionExpr(source string) (*Expr, error) {
+	return compileExpr(source, positionExprVars)
+}
+
+func compileExpr(source string, vars map[string]exprNode) (*Expr, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	var p = &exprParser{source: source, tokens: tokens, vars: vars}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != exprEOF {
+		return nil, p.errorAt(tok, "unexpected %v", tok)
+	}
+	if node.typ != exprBool {
+		return nil, fmt.Errorf("expression %q is %v, expected boolean", source, node.typ)
+	}
+	return &Expr{source: source, eval: node.boolean}, nil
+}
+
+type exprTokenKind int
+
+const (
+	exprEOF exprTokenKind = iota
+	exprNumberToken
+	exprStringToken
+	exprIdent
+	exprOperator
+)
+
+type exprToken struct {
+	kind   exprTokenKind
+	text   string
+	column int
+}
+
+func (t exprToken) String() string {
+	if t.kind == exprEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ","}
+
+func lexExpr(source string) ([]exprToken, error) {
+	var result []exprToken
+	var pos = 0
+	for {
+		for pos < len(source) && unicode.IsSpace(rune(source[pos])) {
+			pos++
+		}
+		if pos >= len(source) {
+			return append(result, exprToken{kind: exprEOF, column: pos + 1}), nil
+		}
+		var start = pos
+		var c = source[pos]
+		switch {
+		case isDigit(c) || c == '.':
+			for pos < len(source) && (isDigit(source[pos]) || source[pos] == '.') {
+				pos++
+			}
+			result = append(result, exprToken{kind: exprNumberToken, text: source[start:pos], column: start + 1})
+		case c == '"' || c == '\'':
+			pos++
+			for pos < len(source) && source[pos] != c {
+				pos++
+			}
+			if pos >= len(source) {
+				return nil, fmt.Errorf("expression %q: column %v: unterminated string", source, start+1)
+			}
+			pos++
+			result = append(result, exprToken{kind: exprStringToken, text: source[start+1 : pos-1], column: start + 1})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			for pos < len(source) && (source[pos] == '_' || isDigit(source[pos]) || unicode.IsLetter(rune(source[pos]))) {
+				pos++
+			}
+			result = append(result, exprToken{kind: exprIdent, text: source[start:pos], column: start + 1})
+		default:
+			var found = false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(source[pos:], op) {
+					pos += len(op)
+					result = append(result, exprToken{kind: exprOperator, text: op, column: start + 1})
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("expression %q: column %v: unexpected character %q", source, start+1, c)
+			}
+		}
+	}
+}
+
+type exprParser struct {
+	source string
+	tokens []exprToken
+	pos    int
+	vars   map[string]exprNode
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	var tok = p.tokens[p.pos]
+	if tok.kind != exprEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isOperator(ops ...string) (string, bool) {
+	var tok = p.peek()
+	if tok.kind == exprOperator {
+		for _, op := range ops {
+			if tok.text == op {
+				p.pos++
+				return op, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.isOperator(op); !ok {
+		return p.errorAt(p.peek(), "expected %q, got %v", op, p.peek())
+	}
+	return nil
+}
+
+func (p *exprParser) errorAt(tok exprToken, format string, args ...interface{}) error {
+	return fmt.Errorf("expression %q: column %v: %v", p.source, tok.column, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseLogical("||", p.parseAnd, func(a, b func(env *exprEnv) bool) func(env *exprEnv) bool {
+		return func(env *exprEnv) bool { return a(env) || b(env) }
+	})
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseLogical("&&", p.parseNot, func(a, b func(env *exprEnv) bool) func(env *exprEnv) bool {
+		return func(env *exprEnv) bool { return a(env) && b(env) }
+	})
+}
+
+func (p *exprParser) parseLogical(op string, operand func() (exprNode, error),
+	combine func(a, b func(env *exprEnv) bool) func(env *exprEnv) bool) (exprNode, error) {
+	var tok = p.peek()
+	left, err := operand()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for {
+		if _, ok := p.isOperator(op); !ok {
+			return left, nil
+		}
+		var rightTok = p.peek()
+		right, err := operand()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if left.typ != exprBool {
+			return exprNode{}, p.errorAt(tok, "operand of %v is %v, expected boolean", op, left.typ)
+		}
+		if right.typ != exprBool {
+			return exprNode{}, p.errorAt(rightTok, "operand of %v is %v, expected boolean", op, right.typ)
+		}
+		left = boolVar(combine(left.boolean, right.boolean))
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	var tok = p.peek()
+	if _, ok := p.isOperator("!"); !ok {
+		return p.parseComparison()
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return exprNode{}, err
+	}
+	if operand.typ != exprBool {
+		return exprNode{}, p.errorAt(tok, "operand of ! is %v, expected boolean", operand.typ)
+	}
+	var f = operand.boolean
+	return boolVar(func(env *exprEnv) bool { return !f(env) }), nil
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return exprNode{}, err
+	}
+	var tok = p.peek()
+	op, ok := p.isOperator("==", "!=", "<=", ">=", "<", ">", "=~", "!~")
+	if !ok {
+		return left, nil
+	}
+	if op == "=~" || op == "!~" {
+		return p.parseMatch(tok, op, left)
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return exprNode{}, err
+	}
+
+	var numeric = left.typ == exprNumber || right.typ == exprNumber ||
+		left.typ == exprTag && right.typ == exprTag && op != "==" && op != "!="
+	switch {
+	case left.typ == exprBool || right.typ == exprBool:
+		if left.typ != right.typ || op != "==" && op != "!=" {
+			return exprNode{}, p.errorAt(tok, "can not compare %v %v %v", left.typ, op, right.typ)
+		}
+		var a, b = left.boolean, right.boolean
+		if op == "==" {
+			return boolVar(func(env *exprEnv) bool { return a(env) == b(env) }), nil
+		}
+		return boolVar(func(env *exprEnv) bool { return a(env) != b(env) }), nil
+	case numeric:
+		var a, okA = asNumber(left)
+		var b, okB = asNumber(right)
+		if !okA || !okB {
+			return exprNode{}, p.errorAt(tok, "can not compare %v %v %v", left.typ, op, right.typ)
+		}
+		return boolVar(compareNumbers(op, a, b)), nil
+	default:
+		var a, b = left.str, right.str
+		return boolVar(compareStrings(op, a, b)), nil
+	}
+}
+
+func (p *exprParser) parseMatch(tok exprToken, op string, left exprNode) (exprNode, error) {
+	var patternTok = p.next()
+	if patternTok.kind != exprStringToken {
+		return exprNode{}, p.errorAt(patternTok, "expected regular expression string after %v, got %v", op, patternTok)
+	}
+	if left.typ != exprString && left.typ != exprTag {
+		return exprNode{}, p.errorAt(tok, "operand of %v is %v, expected string", op, left.typ)
+	}
+	re, err := regexp.Compile(patternTok.text)
+	if err != nil {
+		return exprNode{}, p.errorAt(patternTok, "%v", err)
+	}
+	var s = left.str
+	var negate = op == "!~"
+	return boolVar(func(env *exprEnv) bool { return re.MatchString(s(env)) != negate }), nil
+}
+
+func compareNumbers(op string, a, b func(env *exprEnv) float64) func(env *exprEnv) bool {
+	switch op {
+	case "==":
+		return func(env *exprEnv) bool { return a(env) == b(env) }
+	case "!=":
+		return func(env *exprEnv) bool { return a(env) != b(env) }
+	case "<":
+		return func(env *exprEnv) bool { return a(env) < b(env) }
+	case "<=":
+		return func(env *exprEnv) bool { return a(env) <= b(env) }
+	case ">":
+		return func(env *exprEnv) bool { return a(env) > b(env) }
+	}
+	return func(env *exprEnv) bool { return a(env) >= b(env) }
+}
+
+func compareStrings(op string, a, b func(env *exprEnv) string) func(env *exprEnv) bool {
+	switch op {
+	case "==":
+		return func(env *exprEnv) bool { return a(env) == b(env) }
+	case "!=":
+		return func(env *exprEnv) bool { return a(env) != b(env) }
+	case "<":
+		return func(env *exprEnv) bool { return a(env) < b(env) }
+	case "<=":
+		return func(env *exprEnv) bool { return a(env) <= b(env) }
+	case ">":
+		return func(env *exprEnv) bool { return a(env) > b(env) }
+	}
+	return func(env *exprEnv) bool { return a(env) >= b(env) }
+}
+
+func asNumber(node exprNode) (func(env *exprEnv) float64, bool) {
+	switch node.typ {
+	case exprNumber:
+		return node.num, true
+	case exprTag:
+		var s = node.str
+		return func(env *exprEnv) float64 {
+			var value, err = strconv.ParseFloat(s(env), 64)
+			if err != nil {
+				return math.NaN()
+			}
+			return value
+		}, true
+	}
+	return nil, false
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseArithmetic([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseArithmetic([]string{"*", "/", "%"}, p.parseUnary)
+}
+
+func (p *exprParser) parseArithmetic(ops []string, operand func() (exprNode, error)) (exprNode, error) {
+	var tok = p.peek()
+	left, err := operand()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for {
+		var opTok = p.peek()
+		op, ok := p.isOperator(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return exprNode{}, err
+		}
+		var a, okA = asNumber(left)
+		var b, okB = asNumber(right)
+		if !okA {
+			return exprNode{}, p.errorAt(tok, "operand of %v is %v, expected number", op, left.typ)
+		}
+		if !okB {
+			return exprNode{}, p.errorAt(opTok, "operand of %v is %v, expected number", op, right.typ)
+		}
+		left = numberVar(arithmetic(op, a, b))
+	}
+}
+
+func arithmetic(op string, a, b func(env *exprEnv) float64) func(env *exprEnv) float64 {
+	switch op {
+	case "+":
+		return func(env *exprEnv) float64 { return a(env) + b(env) }
+	case "-":
+		return func(env *exprEnv) float64 { return a(env) - b(env) }
+	case "*":
+		return func(env *exprEnv) float64 { return a(env) * b(env) }
+	case "/":
+		return func(env *exprEnv) float64 { return a(env) / b(env) }
+	}
+	return func(env *exprEnv) float64 { return math.Mod(a(env), b(env)) }
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	var tok = p.peek()
+	if _, ok := p.isOperator("-"); !ok {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	var f, ok = asNumber(operand)
+	if !ok {
+		return exprNode{}, p.errorAt(tok, "operand of - is %v, expected number", operand.typ)
+	}
+	return numberVar(func(env *exprEnv) float64 { return -f(env) }), nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	var tok = p.next()
+	switch tok.kind {
+	case exprNumberToken:
+		var value, err = strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return exprNode{}, p.errorAt(tok, "bad number %v", tok.text)
+		}
+		return numberVar(func(env *exprEnv) float64 { return value }), nil
+	case exprStringToken:
+		var value = tok.text
+		return stringVar(func(env *exprEnv) string { return value }), nil
+	case exprIdent:
+		if _, ok := p.isOperator("("); ok {
+			return p.parseCall(tok)
+		}
+		switch tok.text {
+		case "true", "false":
+			var value = tok.text == "true"
+			return boolVar(func(env *exprEnv) bool { return value }), nil
+		}
+		if node, found := p.vars[tok.text]; found {
+			return node, nil
+		}
+		if unicode.IsUpper(rune(tok.text[0])) {
+			var key = tok.text
+			return exprNode{typ: exprTag, str: func(env *exprEnv) string {
+				var value, _ = tagValue(env.tags, key)
+				return value
+			}}, nil
+		}
+		return exprNode{}, p.errorAt(tok, "unknown identifier %v, expected one of %v", tok.text, p.varNames())
+	case exprOperator:
+		if tok.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			return node, p.expect(")")
+		}
+	}
+	return exprNode{}, p.errorAt(tok, "unexpected %v", tok)
+}
+
+func (p *exprParser) varNames() string {
+	var names []string
+	for name := range p.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = append(names, "tags (WhiteElo, Event, ...)")
+	return strings.Join(names, ", ")
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	var args []exprNode
+	if _, ok := p.isOperator(")"); !ok {
+		for {
+			var argTok = p.peek()
+			arg, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			if _, ok := asNumber(arg); !ok {
+				return exprNode{}, p.errorAt(argTok, "argument of %v is %v, expected number", name.text, arg.typ)
+			}
+			args = append(args, arg)
+			if _, ok := p.isOperator(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return exprNode{}, err
+		}
+	}
+
+	var arity = map[string]int{"abs": 1, "min": 2, "max": 2}
+	var n, found = arity[name.text]
+	if !found {
+		return exprNode{}, p.errorAt(name, "unknown function %v, expected abs, min or max", name.text)
+	}
+	if len(args) != n {
+		return exprNode{}, p.errorAt(name, "function %v expects %v arguments, got %v", name.text, n, len(args))
+	}
+	var a, _ = asNumber(args[0])
+	if name.text == "abs" {
+		return numberVar(func(env *exprEnv) float64 { return math.Abs(a(env)) }), nil
+	}
+	var b, _ = asNumber(args[1])
+	if name.text == "min" {
+		return numberVar(func(env *exprEnv) float64 { return math.Min(a(env), b(env)) }), nil
+	}
+	return numberVar(func(env *exprEnv) float64 { return math.Max(a(env), b(env)) }), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestExpr(t *testing.T) {
+	var p, _ = common.NewPositionFromFEN("4k3/4p3/8/8/8/8/4P3/4K2R b - - 5 40")
+	var tags = []Tag{{"White", "Counter 4.0"}, {"WhiteElo", "3250"}, {"BlackElo", "?"}, {"Date", "2021.10.06"}}
+	var candidate = &Candidate{
+		Position:   &p,
+		Score:      common.UciScore{Centipawns: -350},
+		Depth:      20,
+		Ply:        79,
+		GamePly:    79,
+		GameResult: 1,
+		Tags:       tags,
+	}
+	for _, test := range []struct {
+		source string
+		result bool
+	}{
+		{"ply >= 16 && abs(score) < 800 && pieces <= 12", true},
+		{"WhiteElo > 3200 && !white && halfmove == 5", true},
+		{"BlackElo > 3200 || BlackElo <= 3200", false},
+		{"WhiteElo > BlackElo", false},
+		{"WhiteElo == '3250' && White =~ '^Counter' && White !~ 'Stockfish'", true},
+		{"Date >= \"2021.01.01\" && Event == ''", true},
+		{"phase == 'endgame' && rooks == 1 && pawns == 2 && queens + knights + bishops == 0", true},
+		{"-score - 2 * 50 == 250 && max(depth, 30) % 7 == 2 && min(ply, 10) == 10", true},
+		{"result == 1 && (check || depth > 10) && check == false", true},
+	} {
+		var expr, err = CompilePositionExpr(test.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := expr.eval(&exprEnv{tags: candidate.Tags, candidate: candidate, settings: &AnalyzeSettings{}}); result != test.result {
+			t.Errorf("%v: got %v", test.source, result)
+		}
+	}
+
+	// mate score is converted to centipawns as by max-score filter
+	var mateSettings = &AnalyzeSettings{Mate: MateKeep, MateScore: 3000, PositionFilter: "abs(score) < 800"}
+	if err := mateSettings.compileExpressions(); err != nil {
+		t.Fatal(err)
+	}
+	var filters = mateSettings.newFilterChain(&AllQuietService{})
+	for _, score := range []common.UciScore{{Mate: 5}, {Mate: -4}} {
+		var mate = *candidate
+		mate.Evaluated, mate.Score = true, score
+		if filters.Accept(&mate) {
+			t.Errorf("mate %v: accepted by %v", score.Mate, mateSettings.PositionFilter)
+		}
+	}
+	candidate.Evaluated = true
+	if !filters.Accept(candidate) {
+		t.Errorf("%v: rejected", mateSettings.PositionFilter)
+	}
+
+	for _, test := range []struct {
+		source string
+		err    string
+	}{
+		{"ply >", "column 6: unexpected end of expression"},
+		{"score", "is number, expected boolean"},
+		{"plies > 10", "unknown identifier plies"},
+		{"ply > 'a'", "column 5: can not compare number > string"},
+		{"check && ply", "column 10: operand of && is number"},
+		{"abs(ply, 2) > 0", "function abs expects 1 arguments, got 2"},
+		{"phase =~ '('", "column 10: error parsing regexp"},
+		{"(ply > 1", "expected \")\""},
+		{"ply # 2", "column 5: unexpected character '#'"},
+	} {
+		var _, err = CompilePositionExpr(test.source)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got %v", test.source, err)
+		}
+	}
+
+	var settings = &AnalyzeSettings{GameFilter: "WhiteElo >= 3000 && plies > 4 && termination == 'normal'"}
+	if err := settings.compileExpressions(); err != nil {
+		t.Fatal(err)
+	}
+	var stats = NewStats()
+	var game = "[WhiteElo \"3100\"]\n[Result \"1-0\"]\n\n1. e4 {+0.30/20} e5 {-0.30/20} 2. Nf3 {+0.30/20} Nc6 {-0.30/20} "
+	if positions, err := AnalyzeGame(&AllQuietService{}, settings, nil, game+"3. Bb5 {+0.30/20} 1-0", stats); err != nil || len(positions) != 5 {
+		t.Errorf("got %v %v", len(positions), err)
+	}
+	if positions, err := AnalyzeGame(&AllQuietService{}, settings, nil, game+"1-0", stats); err != nil || len(positions) != 0 ||
+		stats.Counts()[selectionStatsPrefix+"game filter"] != 1 {
+		t.Errorf("got %v %v %v", len(positions), err, stats.Counts())
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const filterStatsPrefix = "filter: "
+
+// FilterSettings configures built-in position filters. Zero limit disables the filter.
+type FilterSettings struct {
+	MinDepth    int
+	MaxDepth    int
+	MinPly      int // ply from initial position, FEN tag move number is taken into account
+	MaxPly      int
+	SkipPlies   int // plies from game start
+	MaxScore    int // absolute centipawn score
+	MaxHalfmove int
+	Check       bool // reject positions in check
+	Repetition  bool // reject positions repeated in game line
+	Quiet       bool // reject not quiet positions
+	// TacticalPlayed and TacticalPV reject positions where the played move or the first engine PV move
+	// is in one of categories capture, promotion, check.
+	TacticalPlayed []string
+	TacticalPV     []string
+	// MaxDisagreement rejects positions where expected result 1/(1+exp(-score/ConsistencyScale))
+	// differs from game result by more than it, both from white point of view. Zero disables.
+	MaxDisagreement  float64
+	ConsistencyScale float64 // centipawns
+}
+
+// Candidate is position considered for dataset.
+// Depth and plies are -1 when not known (EPD and dataset inputs), moves are empty when not known.
+type Candidate struct {
+	Position    *common.Position
+	Castling    Castling
+	Evaluated   bool
+	Score       common.UciScore // side to move point of view
+	Depth       int
+	Ply         int
+	GamePly     int
+	Repetitions int     // occurrences of position earlier in game line
+	BookPlies   int     // book plies of game main line
+	Move        string  // played move, SAN
+	PVMove      string  // first move of engine PV, SAN or LAN
+	GameResult  float32 // white point of view, NoGameResult if not known
+	Tags        []Tag   // game tags, nil for EPD and dataset inputs
+}
+
+// PositionFilter rejects candidates. Filters are used by one thread.
+type PositionFilter interface {
+	Name() string
+	Accept(c *Candidate) bool
+}
+
+// statsFilter is filter with own statistics, e.g. per reason of rejection.
+type statsFilter interface {
+	Flush(stats *Stats)
+}
+
+type funcFilter struct {
+	name   string
+	accept func(c *Candidate) bool
+}
+
+func (f *funcFilter) Name() string             { return f.name }
+func (f *funcFilter) Accept(c *Candidate) bool { return f.accept(c) }
+
+// FilterChain applies filters in order and counts candidates rejected by each filter.
+type FilterChain struct {
+	filters  []PositionFilter
+	rejected []int64
+}
+
+func NewFilterChain(filters ...PositionFilter) *FilterChain {
+	return &FilterChain{
+		filters:  filters,
+		rejected: make([]int64, len(filters)),
+	}
+}
+
+// Add appends filter to the end of chain.
+func (fc *FilterChain) Add(filter PositionFilter) {
+	fc.filters = append(fc.filters, filter)
+	fc.rejected = append(fc.rejected, 0)
+}
+
+func (fc *FilterChain) Accept(c *Candidate) bool {
+	for i, filter := range fc.filters {
+		if !filter.Accept(c) {
+			fc.rejected[i]++
+			return false
+		}
+	}
+	return true
+}
+
+// Flush moves reject counts and statistics of filters to stats.
+func (fc *FilterChain) Flush(stats *Stats) {
+	for i, filter := range fc.filters {
+		if fc.rejected[i] != 0 {
+			stats.Add(filterStatsPrefix+filter.Name(), fc.rejected[i])
+			fc.rejected[i] = 0
+		}
+		if filter, ok := filter.(statsFilter); ok {
+			filter.Flush(stats)
+		}
+	}
+}
+
+// newFilterChain builds built-in filters, cheap filters go first.
+func (settings *AnalyzeSettings) newFilterChain(quietService IQuietService) *FilterChain {
+	var fs = &settings.Filters
+	var result = NewFilterChain()
+	var add = func(enabled bool, name string, accept func(c *Candidate) bool) {
+		if enabled {
+			result.Add(&funcFilter{name: name, accept: accept})
+		}
+	}
+	add(settings.SkipBook, "book", func(c *Candidate) bool {
+		return c.BookPlies < 0 || c.GamePly < 0 || c.GamePly >= c.BookPlies+settings.BookSkipPlies
+	})
+	add(true, "no eval", func(c *Candidate) bool {
+		return c.Evaluated
+	})
+	add(fs.MinDepth != 0, "min depth", func(c *Candidate) bool {
+		return c.Depth < 0 || c.Depth >= fs.MinDepth
+	})
+	add(fs.MaxDepth != 0, "max depth", func(c *Candidate) bool {
+		return c.Depth < 0 || c.Depth <= fs.MaxDepth
+	})
+	add(settings.Mate == MateDrop, "mate", func(c *Candidate) bool {
+		return c.Score.Mate == 0
+	})
+	add(fs.SkipPlies != 0, "skip plies", func(c *Candidate) bool {
+		return c.GamePly < 0 || c.GamePly >= fs.SkipPlies
+	})
+	add(fs.MinPly != 0, "min ply", func(c *Candidate) bool {
+		return c.Ply < 0 || c.Ply >= fs.MinPly
+	})
+	add(fs.MaxPly != 0, "max ply", func(c *Candidate) bool {
+		return c.Ply < 0 || c.Ply <= fs.MaxPly
+	})
+	add(fs.MaxScore != 0, "max score", func(c *Candidate) bool {
+		var score = settings.centipawns(c.Score)
+		return -fs.MaxScore <= score && score <= fs.MaxScore
+	})
+	add(fs.MaxHalfmove != 0, "max halfmove", func(c *Candidate) bool {
+		return c.Position.Rule50 <= fs.MaxHalfmove
+	})
+	add(fs.Check, "check", func(c *Candidate) bool {
+		return !c.Position.IsCheck()
+	})
+	add(fs.Repetition, "repetition", func(c *Candidate) bool {
+		return c.Repetitions == 0
+	})
+	add(len(fs.TacticalPlayed) != 0, "played move", func(c *Candidate) bool {
+		return !isTactical(c.Position, c.Castling, c.Move, fs.TacticalPlayed)
+	})
+	add(len(fs.TacticalPV) != 0, "pv move", func(c *Candidate) bool {
+		return !isTactical(c.Position, c.Castling, c.PVMove, fs.TacticalPV)
+	})
+	add(settings.positionFilter != nil, "expression", func(c *Candidate) bool {
+		return settings.positionFilter.eval(&exprEnv{tags: c.Tags, candidate: c, settings: settings})
+	})
+	if fs.MaxDisagreement != 0 {
+		result.Add(newConsistencyFilter(settings))
+	}
+	add(fs.Quiet, "quiet", func(c *Candidate) bool {
+		return quietService.IsQuiet(c.Position)
+	})
+	return result
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestFilterChain(t *testing.T) {
+	var settings = &AnalyzeSettings{
+		Mate: MateDrop,
+		Filters: FilterSettings{
+			MinDepth:    10,
+			MaxDepth:    30,
+			MinPly:      8,
+			MaxPly:      200,
+			SkipPlies:   4,
+			MaxScore:    1000,
+			MaxHalfmove: 50,
+			Check:       true,
+			Repetition:  true,
+		},
+	}
+	var quiet, _ = common.NewPositionFromFEN("4k3/8/8/8/8/8/4P3/4K3 w - - 3 1")
+	var check, _ = common.NewPositionFromFEN("4k3/8/8/8/8/8/4r3/4K3 w - - 0 1")
+	var rule50, _ = common.NewPositionFromFEN("4k3/8/8/8/8/8/4P3/4K3 w - - 60 1")
+	var good = Candidate{Position: &quiet, Evaluated: true, Depth: 20, Ply: 20, GamePly: 10}
+	var with = func(f func(c *Candidate)) Candidate {
+		var c = good
+		f(&c)
+		return c
+	}
+
+	var filters = settings.newFilterChain(&AllQuietService{})
+	for _, test := range []struct {
+		candidate Candidate
+		rejected  string
+	}{
+		{good, ""},
+		{with(func(c *Candidate) { c.Depth = -1; c.Ply = -1; c.GamePly = -1 }), ""},
+		{with(func(c *Candidate) { c.Evaluated = false }), "no eval"},
+		{with(func(c *Candidate) { c.Depth = 9 }), "min depth"},
+		{with(func(c *Candidate) { c.Depth = 31 }), "max depth"},
+		{with(func(c *Candidate) { c.Score.Mate = 3 }), "mate"},
+		{with(func(c *Candidate) { c.GamePly = 3 }), "skip plies"},
+		{with(func(c *Candidate) { c.Ply = 7 }), "min ply"},
+		{with(func(c *Candidate) { c.Ply = 201 }), "max ply"},
+		{with(func(c *Candidate) { c.Score.Centipawns = -1001 }), "max score"},
+		{with(func(c *Candidate) { c.Position = &rule50 }), "max halfmove"},
+		{with(func(c *Candidate) { c.Position = &check }), "check"},
+		{with(func(c *Candidate) { c.Repetitions = 1 }), "repetition"},
+	} {
+		var stats = NewStats()
+		var accepted = filters.Accept(&test.candidate)
+		filters.Flush(stats)
+		var counts = stats.Counts()
+		if accepted != (test.rejected == "") ||
+			!accepted && (len(counts) != 1 || counts[filterStatsPrefix+test.rejected] != 1) {
+			t.Errorf("%v: got %v %v", test.rejected, accepted, counts)
+		}
+	}
+}
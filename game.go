@@ -3,13 +3,10 @@ package main
 import (
 	"bufio"
 	"context"
-	"errors"
 	"fmt"
-	"os"
-	"regexp"
+	"io"
 	"strconv"
 	"strings"
-	"unicode"
 
 	"github.com/ChizhovVadim/CounterGo/common"
 )
@@ -22,8 +19,10 @@ const (
 )
 
 type Game struct {
-	Tags  []Tag
-	Items []Item
+	Tags     []Tag
+	Items    []Item
+	StartPly int        // ply of the first move counted from initial position
+	BadMove  *GameError // the first illegal move of main line, Items are the moves before it
 }
 
 type Tag struct {
@@ -35,23 +34,21 @@ type Item struct {
 	SanMove    string //for debug
 	TxtComment string //for debug
 	Position   common.Position
+	Castling   Castling
 	Comment    Comment
+	Evaluated  bool     //Comment is recognized by comment parser
+	Variations [][]Item //alternatives to SanMove
 }
 
 func (item Item) String() string {
 	return fmt.Sprintln(item.SanMove, item.TxtComment, item.Comment)
 }
 
-type Comment struct {
-	Depth int
-	Score common.UciScore
-}
-
 func (g *Game) TagValue(key string) (string, bool) {
 	return tagValue(g.Tags, key)
 }
 
-func LoadPgnsManyFiles(ctx context.Context, files []string, pgns chan<- string) error {
+func LoadPgnsManyFiles(ctx context.Context, files []string, pgns chan<- Pgn) error {
 	for _, filepath := range files {
 		var err = LoadPgns(ctx, filepath, pgns)
 		if err != nil {
@@ -61,106 +58,198 @@ func LoadPgnsManyFiles(ctx context.Context, files []string, pgns chan<- string)
 	return nil
 }
 
-func LoadPgns(ctx context.Context, filepath string, pgns chan<- string) error {
-	file, err := os.Open(filepath)
+func LoadPgns(ctx context.Context, filepath string, pgns chan<- Pgn) error {
+	file, err := openPgnFile(filepath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	var sb = &strings.Builder{}
-	var isEmptyPrevLine bool
-
-	var scanner = bufio.NewScanner(file)
-	for scanner.Scan() {
-		var line = scanner.Text()
-		if strings.HasPrefix(line, "[") && isEmptyPrevLine && sb.Len() != 0 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case pgns <- sb.String():
-				sb = &strings.Builder{}
-			}
-		}
-		sb.WriteString(line)
-		sb.WriteString("\n")
-		isEmptyPrevLine = strings.TrimSpace(line) == ""
-	}
+	var splitter = &pgnSplitter{file: filepath}
+	var lineNumber int
+	var offset int64
 
-	if sb.Len() != 0 {
+	var send = func(pgn Pgn) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case pgns <- sb.String():
+		case pgns <- pgn:
+			return nil
 		}
 	}
 
-	return scanner.Err()
+	// bufio.Reader has no line length limit unlike bufio.Scanner
+	var r = bufio.NewReaderSize(file, 1<<16)
+	for {
+		var line, err = r.ReadString('\n')
+		if len(line) != 0 {
+			lineNumber++
+			if pgn, ok := splitter.Add(line, lineNumber, offset); ok {
+				if err := send(pgn); err != nil {
+					return err
+				}
+			}
+			offset += int64(len(line))
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+	}
+
+	if pgn, ok := splitter.Flush(); ok {
+		return send(pgn)
+	}
+	return nil
 }
 
-func ParseGame(pgn string) (Game, error) {
-	var tags = parseTags(pgn)
+// ParseGame parses game text, comments are parsed by commentParser (cutechess if nil).
+// Error is *GameError. Main line is cut at illegal move, which is recorded in BadMove.
+func ParseGame(pgn string, commentParser CommentParser) (Game, error) {
+	if commentParser == nil {
+		commentParser = &CutechessCommentParser{}
+	}
 
+	var tags, movetext, movetextLine, err = parseTagSection(pgn)
+	if err != nil {
+		return Game{}, err
+	}
 	if len(tags) == 0 {
-		return Game{}, fmt.Errorf("empty tags")
+		return Game{}, newGameError(ReasonBadTags, 1, "empty tags")
 	}
 
 	var curPosition = startPosition
-	if fen, fenFound := tagValue(tags, "FEN"); fenFound {
-		var err error
-		curPosition, err = common.NewPositionFromFEN(fen)
+	var castling Castling
+	var fen, fenFound = tagValue(tags, "FEN")
+	if isChess960(tags) {
+		if !fenFound {
+			fen = common.InitialPositionFen
+		}
+		curPosition, castling, err = parseFEN960(fen)
 		if err != nil {
-			return Game{}, fmt.Errorf("parse FEN tag failed")
+			return Game{}, newGameError(ReasonBadFEN, 0, "%v", fen)
+		}
+	} else if fenFound {
+		curPosition, err = common.NewPositionFromFEN(normalizeShredderCastling(fen))
+		if err != nil {
+			return Game{}, newGameError(ReasonBadFEN, 0, "%v", fen)
 		}
 	}
 
-	var tokens = parsePgnTokens(pgn)
-	var items = make([]Item, 0, len(tokens))
+	var lexer = NewPgnLexer(movetext, movetextLine)
+	var items, badMove = parseLine(lexer, commentParser, curPosition, castling, false, len(movetext)/16)
 
-	for i := range tokens {
-		var san = tokens[i].Value
-		var move = common.ParseMoveSAN(&curPosition, san)
-		if move == common.MoveEmpty {
-			break
+	return Game{
+		Tags:     tags,
+		Items:    items,
+		StartPly: fenPly(fen),
+		BadMove:  badMove,
+	}, nil
+}
+
+// parseLine parses moves starting from position until end of variation or game result.
+// Variation is attached to the item whose move it replaces.
+// Moves after the first illegal one are skipped, nested variations are still consumed.
+// The first illegal move is returned as error, callers ignore errors of variations.
+func parseLine(lexer *PgnLexer, commentParser CommentParser, curPosition common.Position, castling Castling, isVariation bool, capacity int) ([]Item, *GameError) {
+	var items = make([]Item, 0, capacity)
+	var stopped bool
+	var badMove *GameError
+
+	for {
+		var token, ok = lexer.Next()
+		if !ok {
+			return shiftAfterMoveComments(items), badMove
 		}
-		var child common.Position
-		if !curPosition.MakeMove(move, &child) {
-			break
+		switch token.Kind {
+		case TokenVariationStart:
+			if len(items) == 0 {
+				parseLine(lexer, commentParser, curPosition, castling, true, 0)
+				continue
+			}
+			var item = &items[len(items)-1]
+			var variation, _ = parseLine(lexer, commentParser, item.Position, item.Castling, true, 0)
+			if len(variation) != 0 {
+				item.Variations = append(item.Variations, variation)
+			}
+		case TokenVariationEnd:
+			if isVariation {
+				return shiftAfterMoveComments(items), badMove
+			}
+		case TokenResult:
+			if !isVariation {
+				return shiftAfterMoveComments(items), badMove
+			}
+		case TokenComment:
+			if !stopped && len(items) != 0 {
+				var item = &items[len(items)-1]
+				if item.TxtComment == "" {
+					item.TxtComment = token.Value
+				} else {
+					item.TxtComment += " " + token.Value
+				}
+				// eval may be split into several comments or followed by comment like {White loses on time}
+				if comment, ok := commentParser.Parse(strings.TrimSpace(item.TxtComment), item.Position.WhiteMove); ok {
+					item.Comment, item.Evaluated = comment, true
+				} else if !item.Evaluated {
+					item.Comment, item.Evaluated = commentParser.Parse(strings.TrimSpace(token.Value), item.Position.WhiteMove)
+				}
+			}
+		case TokenMove:
+			if stopped {
+				continue
+			}
+			var san = token.Value
+			var child, childCastling, ok = makeMoveSAN(&curPosition, castling, san)
+			if !ok {
+				stopped = true
+				badMove = newGameError(ReasonBadSAN, token.Line, "ply %v: %v", len(items)+1, san)
+				continue
+			}
+			items = append(items, Item{
+				SanMove:  san,
+				Position: curPosition,
+				Castling: castling,
+			})
+			curPosition = child
+			castling = childCastling
 		}
+	}
+}
 
-		var comment = Comment{}
-		var txtComment = tokens[i].Comment
-		if txtComment != "" {
-			//var err error
-			comment, _ = parseComment(txtComment)
-			//if err != nil {
-			//	log.Printf("'%v' %v", txtComment, err)
-			//}
+// shiftAfterMoveComments moves evals of positions after moves to the next items,
+// so each item is labelled by eval of its own position. Eval of the final position is dropped.
+func shiftAfterMoveComments(items []Item) []Item {
+	for i := len(items) - 1; i >= 0; i-- {
+		var item = &items[i]
+		if !item.Evaluated || !item.Comment.AfterMove {
+			continue
 		}
-
-		items = append(items, Item{
-			SanMove:    san,
-			TxtComment: txtComment,
-			Position:   curPosition,
-			Comment:    comment,
-		})
-
-		curPosition = child
+		if i+1 < len(items) {
+			items[i+1].Comment, items[i+1].Evaluated = item.Comment, true
+		}
+		item.Comment, item.Evaluated = Comment{}, false
 	}
-
-	return Game{
-		Tags:  tags,
-		Items: items,
-	}, nil
+	return items
 }
 
-func parseTags(pgn string) []Tag {
-	var tags = make([]Tag, 0, 16)
-	tagMatches := tagPairRegex.FindAllStringSubmatch(pgn, -1)
-	for i := range tagMatches {
-		tags = append(tags, Tag{Key: tagMatches[i][1], Value: tagMatches[i][2]})
+// fenPly returns ply of FEN position by side to move and move number, 0 for empty FEN.
+func fenPly(fen string) int {
+	var fields = strings.Fields(fen)
+	if len(fields) < 6 {
+		return 0
+	}
+	var moveNumber, err = strconv.Atoi(fields[5])
+	if err != nil || moveNumber < 1 {
+		return 0
+	}
+	var result = 2 * (moveNumber - 1)
+	if fields[1] == "b" {
+		result++
 	}
-	return tags
+	return result
 }
 
 func tagValue(tags []Tag, key string) (string, bool) {
@@ -172,110 +261,12 @@ func tagValue(tags []Tag, key string) (string, bool) {
 	return "", false
 }
 
-type Token struct {
-	Value   string
-	Comment string
-}
-
-func parsePgnTokens(pgn string) []Token {
-	pgn = tagsRegex.ReplaceAllString(pgn, "")
-	pgn = strings.ReplaceAll(pgn, "\n", " ")
-	var result []Token
-	var inComment = false
-	var body string
-	for _, rune := range pgn {
-		if inComment {
-			if rune == '}' {
-				if len(result) != 0 {
-					result[len(result)-1].Comment = body
-				}
-				inComment = false
-				body = ""
-			} else {
-				body = body + string(rune)
-			}
-		} else if unicode.IsSpace(rune) {
-			if body != "" {
-				if !strings.HasSuffix(body, ".") {
-					result = append(result, Token{Value: body})
-				}
-				body = ""
-			}
-		} else if rune == '{' {
-			if body != "" {
-				if !strings.HasSuffix(body, ".") {
-					result = append(result, Token{Value: body})
-				}
-				body = ""
-			}
-			inComment = true
-			body = ""
-		} else {
-			body = body + string(rune)
-		}
-	}
-	return result
-}
-
-//TODO парсить без ошибок '0s'
-func parseComment(comment string) (Comment, error) {
-	comment = strings.TrimLeft(comment, "{")
-	comment = strings.TrimRight(comment, "}")
-
-	if comment == "book" {
-		return Comment{}, nil
-	}
-
-	var fields = strings.Fields(comment)
-	if len(fields) >= 2 {
-		var s string
-		if strings.HasPrefix(fields[0], "(") {
-			s = fields[1]
-		} else {
-			s = fields[0]
-		}
-		if s != "" {
-			var index = strings.Index(s, "/")
-			if index >= 0 {
-				var sScore = s[:index]
-				var sDepth = s[index+1:]
-
-				var uciScore common.UciScore
-				if strings.Contains(sScore, "M") {
-					sScore = strings.Replace(sScore, "M", "", 1)
-					score, err := strconv.Atoi(sScore)
-					if err != nil {
-						return Comment{}, err
-					}
-					uciScore = common.UciScore{Mate: score}
-				} else {
-					score, err := strconv.ParseFloat(sScore, 64)
-					if err != nil {
-						return Comment{}, err
-					}
-					uciScore = common.UciScore{Centipawns: int(100 * score)}
-				}
-
-				depth, err := strconv.Atoi(sDepth)
-				if err != nil {
-					return Comment{}, err
-				}
-				return Comment{
-					Score: uciScore,
-					Depth: depth,
-				}, nil
-			}
-		}
+// normalizeCastling converts "0-0" and "0-0-0" castling notation to "O-O" and "O-O-O".
+func normalizeCastling(san string) string {
+	if strings.HasPrefix(san, "0-0") {
+		return strings.Replace(san, "0", "O", -1)
 	}
-	return Comment{}, errParseComment
+	return san
 }
 
-var errParseComment = errors.New("parse comment failed")
 var startPosition, _ = common.NewPositionFromFEN(common.InitialPositionFen)
-
-//TODO В идеале парсить и такие теги
-//[Variation "Abbazia defence (classical defence, modern defence[!])"]
-var (
-	tagsRegex    = regexp.MustCompile(`\[[^\]]+\]`)
-	tagPairRegex = regexp.MustCompile(`\[(.*)\s\"(.*)\"\]`)
-)
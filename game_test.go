@@ -1,17 +1,165 @@
 package main
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
 )
 
 func TestPgn(t *testing.T) {
-	var game, err = ParseGame(pgn)
+	var game, err = ParseGame(pgn, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Log(game)
 }
 
+func TestTags(t *testing.T) {
+	const pgn = `[Event "CCRL \"40/15\""]
+  [Variation "Abbazia defence (classical defence, modern defence[!])"]
+[White "John "Doe""][Result "1-0"]
+%escaped line
+
+1. e4 {+0.30/20 1s} 1-0
+`
+	var game, err = ParseGame(pgn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = []Tag{
+		{"Event", `CCRL "40/15"`},
+		{"Variation", "Abbazia defence (classical defence, modern defence[!])"},
+		{"White", `John "Doe"`},
+		{"Result", "1-0"},
+	}
+	if fmt.Sprint(game.Tags) != fmt.Sprint(expected) {
+		t.Fatalf("got %v", game.Tags)
+	}
+	if len(game.Items) != 1 || game.Items[0].Comment.Depth != 20 {
+		t.Fatalf("bad movetext %v", game.Items)
+	}
+}
+
+func TestChess960(t *testing.T) {
+	const pgn = `[Event "FRC"]
+[Variant "Chess960"]
+[FEN "rk1r4/pppppppp/8/8/8/8/PPPPPPPP/RK1R4 w KQkq - 0 1"]
+[Result "*"]
+
+1. O-O a6 2. Rad1 Kxd8 *
+`
+	var game, err = ParseGame(pgn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Items) != 4 {
+		t.Fatalf("got %v moves", len(game.Items))
+	}
+	for _, test := range []struct {
+		ply      int
+		format   string
+		expected string
+	}{
+		{0, CastlingXFEN, "rk1r4/pppppppp/8/8/8/8/PPPPPPPP/RK1R4 w KQkq - 0 1"},
+		{0, CastlingShredder, "rk1r4/pppppppp/8/8/8/8/PPPPPPPP/RK1R4 w DAda - 0 1"},
+		{1, CastlingXFEN, "rk1r4/pppppppp/8/8/8/8/PPPPPPPP/R4RK1 b kq - 1 1"},
+		{3, CastlingShredder, "rk1r4/1ppppppp/p7/8/8/8/PPPPPPPP/3R1RK1 b da - 1 1"},
+	} {
+		var item = &game.Items[test.ply]
+		var fen = formatFEN(&item.Position, item.Castling, test.format)
+		if fen != test.expected {
+			t.Fatalf("ply %v: got %v, expected %v", test.ply, fen, test.expected)
+		}
+	}
+	var last = game.Items[3]
+	var child, _, ok = makeMoveSAN(&last.Position, last.Castling, last.SanMove)
+	if !ok || child.String() != "r4rk1/1ppppppp/p7/8/8/8/PPPPPPPP/3R1RK1 w - - 2 2" {
+		t.Fatalf("bad castling king takes rook %v", child.String())
+	}
+}
+
+func TestVariations(t *testing.T) {
+	const pgn = `[Event "Analysis"]
+[Result "1-0"]
+
+1. e4 {+0.30/20 1s} e5 {-0.20/20 1s} (1... c5 {-0.25/18 1s} 2. Nf3 {+0.30/18 1s}
+(2. Nc3 {+0.20/18 1s}) d6) 2. Nf3 {+0.30/20 1s} 1-0
+`
+	var game, err = ParseGame(pgn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Items) != 3 || len(game.Items[1].Variations) != 1 ||
+		len(game.Items[1].Variations[0]) != 3 ||
+		len(game.Items[1].Variations[0][1].Variations) != 1 {
+		t.Fatalf("bad game tree %v", game.Items)
+	}
+
+	for _, test := range []struct {
+		variations string
+		positions  int
+	}{
+		{VariationsOff, 3},
+		{VariationsResult, 4},
+		{VariationsNoResult, 4},
+	} {
+		var positions, err = AnalyzeGame(&AllQuietService{}, &AnalyzeSettings{Variations: test.variations}, nil, pgn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(positions) != test.positions {
+			t.Fatalf("%v: got %v positions, expected %v", test.variations, len(positions), test.positions)
+		}
+		// the first position of variation is the position of the main line move it replaces
+		var noResult int
+		var seen = make(map[uint64]bool)
+		for _, p := range positions {
+			if p.gameResult == NoGameResult {
+				noResult++
+			}
+			if seen[p.position.Key] {
+				t.Errorf("%v: position %v is written twice", test.variations, p.position.String())
+			}
+			seen[p.position.Key] = true
+		}
+		if test.variations == VariationsNoResult && noResult != 1 {
+			t.Fatalf("got %v positions without result", noResult)
+		}
+	}
+}
+
+func TestLichessEvals(t *testing.T) {
+	// eval follows the move it evaluates, 3... Nf6?? allows mate
+	const pgn = `[Event "Rated Blitz game"]
+[Result "1-0"]
+
+1. e4 { [%eval 0.3] } 1... e5 { [%eval 0.25] } 2. Qh5 { [%eval -0.1] } 2... Nc6 { [%eval 0.0] }
+3. Bc4 { [%eval 0.5] } 3... Nf6?? { [%eval #1] } 4. Qxf7# 1-0
+`
+	var game, err = ParseGame(pgn, &LichessCommentParser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Items) != 7 || game.Items[0].Evaluated {
+		t.Fatalf("bad game %v", game.Items)
+	}
+	// scores of positions before moves from side to move point of view
+	for i, expected := range []common.UciScore{{Centipawns: -30}, {Centipawns: 25}, {Centipawns: 10}, {}, {Centipawns: -50}, {Mate: 1}} {
+		var item = game.Items[i+1]
+		if !item.Evaluated || item.Comment.Score != expected {
+			t.Errorf("ply %v %v: got %+v, expected %+v", i+2, item.SanMove, item.Comment.Score, expected)
+		}
+	}
+
+	// evals without depth are not dropped by minimum depth
+	var settings = &AnalyzeSettings{Mate: MateDrop, Filters: FilterSettings{MinDepth: 10}}
+	positions, err := AnalyzeGame(&AllQuietService{}, settings, &LichessCommentParser{}, pgn, nil)
+	if err != nil || len(positions) != 5 {
+		t.Errorf("got %v positions, %v", len(positions), err)
+	}
+}
+
 const pgn = `[Event "CCRL 40/15"]
 [Site "CCRL"]
 [Date "2021.10.06"]
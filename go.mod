@@ -4,5 +4,7 @@ go 1.15
 
 require (
 	github.com/ChizhovVadim/CounterGo v1.41.0
+	github.com/klauspost/compress v1.13.6
+	github.com/ulikunitz/xz v0.5.10
 	golang.org/x/sync v0.0.0-20220601150217-0de741cfad7f
 )
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const stdinInput = "-"
+
+// stringList is a repeatable command line flag.
+// Each value may also contain several comma separated items.
+// The first value replaces the initial list, e.g. loaded from config.
+type stringList struct {
+	list *[]string
+	set  bool
+}
+
+func newStringList(list *[]string) *stringList {
+	return &stringList{list: list}
+}
+
+func (l *stringList) String() string {
+	if l == nil || l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	l.Append(items...)
+	return nil
+}
+
+// Append adds items, the first call replaces the initial list.
+func (l *stringList) Append(items ...string) {
+	if !l.set {
+		*l.list = nil
+		l.set = true
+	}
+	*l.list = append(*l.list, items...)
+}
+
+type InputFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Accept checks file found under root folder against include and exclude glob patterns.
+// Pattern without slash is matched against base name,
+// otherwise against path relative to root or full path.
+func (f *InputFilter) Accept(root, filePath string) bool {
+	if len(f.Include) != 0 && !matchAnyGlob(f.Include, root, filePath) {
+		return false
+	}
+	return !matchAnyGlob(f.Exclude, root, filePath)
+}
+
+// findInputFiles expands inputs into list of files.
+// Input may be a file, a directory (walked recursively), a glob pattern (with "**" support) or "-" for stdin.
+// Files of directories and glob patterns are selected by isInput of their names.
+func findInputFiles(inputs []string, filter InputFilter, isInput func(name string) bool) ([]string, error) {
+	var result []string
+	var seen = make(map[string]struct{})
+	var add = func(filePath string) {
+		if _, found := seen[filePath]; found {
+			return
+		}
+		seen[filePath] = struct{}{}
+		result = append(result, filePath)
+	}
+
+	for _, input := range inputs {
+		if input == stdinInput {
+			add(input)
+			continue
+		}
+		if hasGlobMeta(input) {
+			files, err := expandGlob(input, filter, isInput)
+			if err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("no input files match %v", input)
+			}
+			for _, file := range files {
+				add(file)
+			}
+			continue
+		}
+		stat, err := os.Stat(input)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			// explicitly named file is taken as is
+			add(input)
+			continue
+		}
+		err = walkInputFiles(input, func(filePath string) bool {
+			return isInput(filepath.Base(filePath)) && filter.Accept(input, filePath)
+		}, add)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func walkInputFiles(root string, accept func(filePath string) bool, add func(filePath string)) error {
+	return filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if accept(filePath) {
+			add(filePath)
+		}
+		return nil
+	})
+}
+
+// expandGlob expands pattern like shell: "*", "?" and "[" match within one directory level
+// and matched folders are walked recursively. Only pattern with "**" walks from its root.
+func expandGlob(pattern string, filter InputFilter, isInput func(name string) bool) ([]string, error) {
+	var result []string
+	var add = func(filePath string) {
+		result = append(result, filePath)
+	}
+	if !strings.Contains(pattern, "**") {
+		var matches, err = filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		var root = globRoot(pattern)
+		for _, match := range matches {
+			stat, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !stat.IsDir() {
+				if isInput(filepath.Base(match)) && filter.Accept(root, match) {
+					add(match)
+				}
+				continue
+			}
+			var dir = match
+			err = walkInputFiles(dir, func(filePath string) bool {
+				return isInput(filepath.Base(filePath)) && filter.Accept(dir, filePath)
+			}, add)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	var root = globRoot(pattern)
+	if _, err := os.Stat(root); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var err = walkInputFiles(root, func(filePath string) bool {
+		return isInput(filepath.Base(filePath)) && matchPath(pattern, filePath) && filter.Accept(root, filePath)
+	}, add)
+	return result, err
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// globRoot returns longest directory prefix of pattern without glob meta characters.
+func globRoot(pattern string) string {
+	var segments = strings.Split(filepath.ToSlash(pattern), "/")
+	var i = 0
+	for i < len(segments) && !hasGlobMeta(segments[i]) {
+		i++
+	}
+	var root = strings.Join(segments[:i], "/")
+	if root == "" {
+		if strings.HasPrefix(pattern, "/") {
+			return "/"
+		}
+		return "."
+	}
+	return filepath.FromSlash(root)
+}
+
+func matchAnyGlob(patterns []string, root, filePath string) bool {
+	var relPath, err = filepath.Rel(root, filePath)
+	if err != nil {
+		relPath = filePath
+	}
+	for _, pattern := range patterns {
+		if matchGlob(pattern, filePath) || matchGlob(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches shell-style pattern. Pattern without slash is matched against base name.
+// Segment "**" matches any number of directories.
+func matchGlob(pattern, filePath string) bool {
+	pattern = filepath.ToSlash(pattern)
+	filePath = filepath.ToSlash(filepath.Clean(filePath))
+	if !strings.Contains(pattern, "/") {
+		var ok, _ = path.Match(pattern, path.Base(filePath))
+		return ok
+	}
+	return matchPath(pattern, filePath)
+}
+
+// matchPath matches shell-style pattern against the whole path.
+// Segment "**" matches any number of directories.
+func matchPath(pattern, filePath string) bool {
+	pattern = filepath.ToSlash(pattern)
+	filePath = filepath.ToSlash(filepath.Clean(filePath))
+	return matchSegments(
+		strings.Split(strings.TrimPrefix(path.Clean(pattern), "./"), "/"),
+		strings.Split(filePath, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindInputFiles(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.pgn", "b.epd", "notes.txt", "blitz.pgn", "sub/c.pgn.gz", "sub/deep/d.pgn", "sub/deep/e.txt"} {
+		var filePath = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		inputs   []string
+		filter   InputFilter
+		expected []string
+	}{
+		{[]string{"."}, InputFilter{}, []string{"a.pgn", "b.epd", "blitz.pgn", "sub/c.pgn.gz", "sub/deep/d.pgn"}},
+		{[]string{"*.pgn"}, InputFilter{}, []string{"a.pgn", "blitz.pgn"}},
+		{[]string{"sub/*"}, InputFilter{}, []string{"sub/c.pgn.gz", "sub/deep/d.pgn"}},
+		// no match is an error
+		{[]string{"*/*.pgn"}, InputFilter{}, nil},
+		{[]string{"**/*.pgn"}, InputFilter{}, []string{"a.pgn", "blitz.pgn", "sub/deep/d.pgn"}},
+		{[]string{"sub/**"}, InputFilter{}, []string{"sub/c.pgn.gz", "sub/deep/d.pgn"}},
+		{[]string{"notes.txt", "-", "a.pgn", "*.pgn"}, InputFilter{}, []string{"notes.txt", "-", "a.pgn", "blitz.pgn"}},
+		{[]string{"."}, InputFilter{Include: []string{"*.pgn*"}, Exclude: []string{"*blitz*", "sub/deep/**"}},
+			[]string{"a.pgn", "sub/c.pgn.gz"}},
+		{[]string{"**/*.pgn"}, InputFilter{Exclude: []string{"sub/deep/*"}}, []string{"a.pgn", "blitz.pgn"}},
+	} {
+		var files, err = findInputFiles(test.inputs, test.filter, isInputFile)
+		if (err != nil) != (test.expected == nil) {
+			t.Errorf("%v: got %v, %v", test.inputs, files, err)
+			continue
+		}
+		var expected []string
+		for _, name := range test.expected {
+			expected = append(expected, filepath.FromSlash(name))
+		}
+		if !reflect.DeepEqual(files, expected) {
+			t.Errorf("%v %+v: got %v, expected %v", test.inputs, test.filter, files, expected)
+		}
+	}
+
+	if _, err := findInputFiles([]string{"missing.pgn"}, InputFilter{}, isInputFile); err == nil {
+		t.Error("missing file found")
+	}
+	if _, err := findInputFiles([]string{"missing/*.pgn"}, InputFilter{}, isInputFile); err == nil {
+		t.Error("missing pattern matched")
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range []struct {
+		pattern, filePath string
+		expected          bool
+	}{
+		{"*.pgn", "a.pgn", true},
+		{"*.pgn", "sub/a.pgn", true},
+		{"*.pgn", "a.pgn.gz", false},
+		{"sub/*.pgn", "sub/a.pgn", true},
+		{"sub/*.pgn", "sub/deep/a.pgn", false},
+		{"sub/**/*.pgn", "sub/a.pgn", true},
+		{"sub/**/*.pgn", "sub/deep/deeper/a.pgn", true},
+		{"**/a.pgn", "a.pgn", true},
+		{"./sub/?.pgn", "sub/a.pgn", true},
+		{"sub/[ab].pgn", "sub/c.pgn", false},
+		{"/data/**", "/data/x/a.pgn", true},
+	} {
+		if matched := matchGlob(test.pattern, test.filePath); matched != test.expected {
+			t.Errorf("%v %v: got %v", test.pattern, test.filePath, matched)
+		}
+	}
+	if matchPath("*.pgn", "sub/a.pgn") {
+		t.Error("path pattern matched base name")
+	}
+}
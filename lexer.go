@@ -0,0 +1,187 @@
+package main
+
+import "strings"
+
+type TokenKind uint8
+
+const (
+	TokenMove TokenKind = iota
+	TokenComment
+	TokenNAG
+	TokenVariationStart
+	TokenVariationEnd
+	TokenResult
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenMove:
+		return "move"
+	case TokenComment:
+		return "comment"
+	case TokenNAG:
+		return "nag"
+	case TokenVariationStart:
+		return "("
+	case TokenVariationEnd:
+		return ")"
+	case TokenResult:
+		return "result"
+	}
+	return "unknown"
+}
+
+// Token value is a substring of lexer source, so scanning does not allocate.
+// Comment value is text between braces or after semicolon.
+// NAG value is either "$N" or suffix annotation like "!?".
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Line  int
+}
+
+// PgnLexer splits PGN movetext into tokens according to PGN standard:
+// move number indications are skipped, "%" escape lines are ignored.
+type PgnLexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func NewPgnLexer(movetext string, line int) *PgnLexer {
+	return &PgnLexer{src: movetext, line: line}
+}
+
+func (l *PgnLexer) Line() int {
+	return l.line
+}
+
+func (l *PgnLexer) Next() (Token, bool) {
+	for l.pos < len(l.src) {
+		var c = l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.pos++
+			l.line++
+			if l.pos < len(l.src) && l.src[l.pos] == '%' {
+				l.skipLine()
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f':
+			l.pos++
+		case c == '%' && l.pos == 0:
+			l.skipLine()
+		case c == '{':
+			var line = l.line
+			var start = l.pos + 1
+			var end = start
+			for end < len(l.src) && l.src[end] != '}' {
+				if l.src[end] == '\n' {
+					l.line++
+				}
+				end++
+			}
+			l.pos = min(end+1, len(l.src))
+			return Token{Kind: TokenComment, Value: l.src[start:end], Line: line}, true
+		case c == ';':
+			var start = l.pos + 1
+			l.skipLine()
+			return Token{Kind: TokenComment, Value: l.src[start:l.pos], Line: l.line}, true
+		case c == '(':
+			l.pos++
+			return Token{Kind: TokenVariationStart, Value: l.src[l.pos-1 : l.pos], Line: l.line}, true
+		case c == ')':
+			l.pos++
+			return Token{Kind: TokenVariationEnd, Value: l.src[l.pos-1 : l.pos], Line: l.line}, true
+		case c == '$':
+			var start = l.pos
+			l.pos++
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+			return Token{Kind: TokenNAG, Value: l.src[start:l.pos], Line: l.line}, true
+		case c == '!' || c == '?':
+			var start = l.pos
+			for l.pos < len(l.src) && (l.src[l.pos] == '!' || l.src[l.pos] == '?') {
+				l.pos++
+			}
+			return Token{Kind: TokenNAG, Value: l.src[start:l.pos], Line: l.line}, true
+		case c == '*':
+			l.pos++
+			return Token{Kind: TokenResult, Value: l.src[l.pos-1 : l.pos], Line: l.line}, true
+		case isSymbolStart(c):
+			if token, ok := l.symbol(); ok {
+				return token, true
+			}
+		default:
+			// unknown character
+			l.pos++
+		}
+	}
+	return Token{}, false
+}
+
+// enPassantSuffix is written after en passant captures by some tools, e.g. "exf6 e.p.".
+const enPassantSuffix = "e.p."
+
+// symbol scans move, result or move number indication. Move number and en passant suffix are skipped.
+func (l *PgnLexer) symbol() (Token, bool) {
+	var start = l.pos
+	if strings.HasPrefix(l.src[start:], enPassantSuffix) {
+		l.pos += len(enPassantSuffix)
+		return Token{}, false
+	}
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.pos > start && l.pos < len(l.src) && l.src[l.pos] == '.' {
+		// "12." or "12..."
+		for l.pos < len(l.src) && l.src[l.pos] == '.' {
+			l.pos++
+		}
+		return Token{}, false
+	}
+	for l.pos < len(l.src) && isSymbolContinuation(l.src[l.pos]) {
+		l.pos++
+	}
+	var value = l.src[start:l.pos]
+	if strings.HasSuffix(value, "e") && strings.HasPrefix(l.src[l.pos:], enPassantSuffix[1:]) {
+		// "exf6e.p.", SAN move never ends with "e"
+		value = value[:len(value)-1]
+		l.pos += len(enPassantSuffix) - 1
+	}
+	if isDigits(value) {
+		// move number without period
+		return Token{}, false
+	}
+	if value == GameResultWhiteWin || value == GameResultBlackWin || value == GameResultDraw {
+		return Token{Kind: TokenResult, Value: value, Line: l.line}, true
+	}
+	return Token{Kind: TokenMove, Value: value, Line: l.line}, true
+}
+
+func (l *PgnLexer) skipLine() {
+	for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+		l.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSymbolStart(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isSymbolContinuation(c byte) bool {
+	return isSymbolStart(c) || c == '_' || c == '+' || c == '#' || c == '=' || c == ':' || c == '-' || c == '/'
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestPgnLexer(t *testing.T) {
+	const movetext = `1. e4 $1 {best by test} 1...c5!? (1...e5 2. Nf3 (2. f4) Nc6) 2.Nf3 ; rest of line
+% escaped line
+2... d6?! 3. O-O-O# 4. exd6 e.p. dxe6e.p.+ 1/2-1/2`
+	var expected = []string{
+		"move e4", "nag $1", "comment best by test",
+		"move c5", "nag !?",
+		"( (", "move e5", "move Nf3", "( (", "move f4", ") )", "move Nc6", ") )",
+		"move Nf3", "comment  rest of line",
+		"move d6", "nag ?!", "move O-O-O#", "move exd6", "move dxe6", "result 1/2-1/2",
+	}
+	var lexer = NewPgnLexer(movetext, 1)
+	var actual []string
+	for {
+		var token, ok = lexer.Next()
+		if !ok {
+			break
+		}
+		actual = append(actual, token.Kind.String()+" "+token.Value)
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("got\n%v\nexpected\n%v", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	if lexer.Line() != 3 {
+		t.Fatalf("got line %v", lexer.Line())
+	}
+}
+
+func benchmarkMovetext() string {
+	var _, movetext, _, _ = parseTagSection(pgn)
+	return strings.Repeat(movetext, 20)
+}
+
+func BenchmarkPgnLexer(b *testing.B) {
+	var movetext = benchmarkMovetext()
+	b.SetBytes(int64(len(movetext)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var lexer = NewPgnLexer(movetext, 1)
+		for {
+			if _, ok := lexer.Next(); !ok {
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkLegacyTokens measures tokenizer replaced by PgnLexer.
+func BenchmarkLegacyTokens(b *testing.B) {
+	var movetext = benchmarkMovetext()
+	b.SetBytes(int64(len(movetext)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyPgnTokens(movetext)
+	}
+}
+
+type legacyToken struct {
+	Value   string
+	Comment string
+}
+
+func legacyPgnTokens(pgn string) []legacyToken {
+	pgn = strings.ReplaceAll(pgn, "\n", " ")
+	var result []legacyToken
+	var inComment = false
+	var body string
+	for _, rune := range pgn {
+		if inComment {
+			if rune == '}' {
+				if len(result) != 0 {
+					result[len(result)-1].Comment = body
+				}
+				inComment = false
+				body = ""
+			} else {
+				body = body + string(rune)
+			}
+		} else if unicode.IsSpace(rune) || rune == '{' {
+			if body != "" {
+				if !strings.HasSuffix(body, ".") {
+					result = append(result, legacyToken{Value: body})
+				}
+				body = ""
+			}
+			inComment = rune == '{'
+		} else {
+			body = body + string(rune)
+		}
+	}
+	return result
+}
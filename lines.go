@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"strconv"
+)
+
+const (
+	FormatEpd     = "epd"
+	FormatDataset = "dataset"
+)
+
+const lineBatchSize = 1024
+
+// LineBatch is a group of consecutive lines of file with one position per line.
+type LineBatch struct {
+	File   string
+	Format string
+	Line   int // line number of the first line
+	Lines  []string
+}
+
+func LoadLinesManyFiles(ctx context.Context, files []string, format string, batches chan<- LineBatch) error {
+	for _, filepath := range files {
+		var err = LoadLines(ctx, filepath, format, batches)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func LoadLines(ctx context.Context, filepath string, format string, batches chan<- LineBatch) error {
+	file, err := openPgnFile(filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var batch = LineBatch{File: filepath, Format: format, Line: 1}
+	var lineNumber int
+
+	var send = func() error {
+		if len(batch.Lines) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case batches <- batch:
+		}
+		batch = LineBatch{File: filepath, Format: format, Line: lineNumber + 1,
+			Lines: make([]string, 0, lineBatchSize)}
+		return nil
+	}
+
+	var r = bufio.NewReaderSize(file, 1<<16)
+	for {
+		var line, err = r.ReadString('\n')
+		if len(line) != 0 {
+			lineNumber++
+			batch.Lines = append(batch.Lines, line)
+			if len(batch.Lines) == lineBatchSize {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+	}
+	return send()
+}
+
+func analyzeLines(
+	ctx context.Context,
+	quietService IQuietService,
+	settings *AnalyzeSettings,
+	stats *Stats,
+	batches <-chan LineBatch,
+	games chan<- []PositionInfo,
+) error {
+	var filters = settings.newFilterChain(quietService)
+	for batch := range batches {
+		var positions []PositionInfo
+		for i, line := range batch.Lines {
+			var positionInfo PositionInfo
+			var ok bool
+			var err error
+			switch batch.Format {
+			case FormatEpd:
+				positionInfo, ok, err = AnalyzeEpd(filters, settings, line)
+			case FormatDataset:
+				positionInfo, ok, err = AnalyzeDatasetLine(filters, settings, line)
+			}
+			if err != nil {
+				log.Printf("Analyze %v error %v:%v %v", batch.Format, batch.File, batch.Line+i, err)
+				stats.Add(errorStatsPrefix+"bad "+batch.Format+" line", 1)
+				continue
+			}
+			if ok {
+				positions = append(positions, positionInfo)
+			}
+		}
+		filters.Flush(stats)
+		if len(positions) != 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case games <- positions:
+			}
+		}
+	}
+	return nil
+}
+
+// parseGameResult parses "1-0", "0-1", "1/2-1/2", "*" or number 1, 0.5, 0.
+func parseGameResult(s string) (float32, bool) {
+	switch s {
+	case GameResultWhiteWin:
+		return 1, true
+	case GameResultBlackWin:
+		return 0, true
+	case GameResultDraw:
+		return 0.5, true
+	case GameResultNone:
+		return NoGameResult, true
+	}
+	var result, err = strconv.ParseFloat(s, 32)
+	if err != nil || result != 0 && result != 0.5 && result != 1 {
+		return 0, false
+	}
+	return float32(result), true
+}
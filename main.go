@@ -4,11 +4,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 
 	"github.com/ChizhovVadim/CounterGo/common"
@@ -31,13 +32,28 @@ func main() {
 	var err = run()
 	if err != nil {
 		log.Println(err)
+		os.Exit(1)
 	}
 }
 
 type Settings struct {
-	GamesFolder string
+	Inputs      []string
+	Datasets    []string
+	InputFilter InputFilter
 	ResultPath  string
+	Castling    string
 	Threads     int
+	Readers     int
+	ChunkSizeMB int
+	Quarantine  string
+	Strict      bool
+	// MaxErrorRate is allowed share of rejected games in strict mode
+	MaxErrorRate float64
+	Analyze      AnalyzeSettings
+	// DuplicateGames skips repeated games of all PGN inputs
+	DuplicateGames DuplicateGameSettings
+	Dedup          DedupSettings
+	Balance        BalanceSettings
 }
 
 func run() error {
@@ -53,35 +69,247 @@ func run() error {
 	var chessDir = filepath.Join(homeDir, "chess")
 
 	var settings = Settings{
-		GamesFolder: filepath.Join(chessDir, "pgn"),
-		ResultPath:  filepath.Join(chessDir, "fengen.txt"),
-		Threads:     max(1, runtime.NumCPU()/2),
+		ResultPath:   filepath.Join(chessDir, "fengen.txt"),
+		Threads:      max(1, runtime.NumCPU()/2),
+		Castling:     CastlingXFEN,
+		Readers:      1,
+		ChunkSizeMB:  256,
+		MaxErrorRate: 0.01,
+		Dedup:        DedupSettings{MemoryMB: 1024},
+		Analyze: AnalyzeSettings{
+			Variations:          VariationsOff,
+			Mate:                MateDrop,
+			MateScore:           3000,
+			DatasetSeparator:    DatasetSeparatorAuto,
+			AdjudicateWinScore:  1000,
+			AdjudicateWinMoves:  3,
+			AdjudicateDrawScore: 10,
+			AdjudicateDrawMoves: 8,
+			BookUntilEval:       true,
+			Filters: FilterSettings{
+				MinDepth:         10,
+				Check:            true,
+				Repetition:       true,
+				Quiet:            true,
+				ConsistencyScale: 400,
+			},
+		},
 	}
+	var defaultInput = filepath.Join(chessDir, "pgn")
 
-	flag.StringVar(&settings.GamesFolder, "input", settings.GamesFolder, "Path to folder with PGN files")
+	var configPath = findConfigArg(os.Args[1:])
+	if configPath != "" {
+		err = loadConfig(configPath, &settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	flag.String("config", configPath, "JSON file with settings (as in output .meta.json), other flags override it")
+	var inputs = newStringList(&settings.Inputs)
+	flag.Var(inputs, "input", fmt.Sprintf("Comma separated or repeated PGN or EPD inputs (.pgn, .epd, optionally compressed .gz, .bz2, .xz, .zst): files, folders (recursive), glob patterns or - for PGN stdin (default %q)", defaultInput))
+	flag.Var(newStringList(&settings.Datasets), "dataset", "Comma separated or repeated fen;score;result files (output of fengen) to filter again: files, folders (recursive), glob patterns or - for stdin")
+	flag.StringVar(&settings.Analyze.DatasetSeparator, "dataset-separator", settings.Analyze.DatasetSeparator, "Separator of dataset columns fen, score (white point of view), result: auto (one of ; | , tab or space) or any string")
+	flag.Var(newStringList(&settings.InputFilter.Include), "include", "Glob pattern of input files to take from folders (repeatable)")
+	flag.Var(newStringList(&settings.InputFilter.Exclude), "exclude", "Glob pattern of input files to skip in folders (repeatable)")
 	flag.StringVar(&settings.ResultPath, "output", settings.ResultPath, "Path to output fen file")
+	flag.StringVar(&settings.Castling, "castling", settings.Castling, "Castling field of output FEN: xfen (KQkq, file letters for Chess960 inner rooks) or shredder (file letters)")
 	flag.IntVar(&settings.Threads, "threads", settings.Threads, "Number of threads")
+	flag.IntVar(&settings.Readers, "readers", settings.Readers, "Number of concurrent PGN readers. Large plain PGN files are split into chunks read in parallel")
+	flag.IntVar(&settings.ChunkSizeMB, "chunk-size", settings.ChunkSizeMB, "Chunk size in MB for parallel reading of large PGN files")
+	flag.StringVar(&settings.Quarantine, "quarantine", settings.Quarantine, "Path to PGN file for rejected games (with reason in % comment line)")
+	flag.BoolVar(&settings.Strict, "strict", settings.Strict, "Fail with non-zero exit code if share of rejected games exceeds max-error-rate")
+	flag.Float64Var(&settings.MaxErrorRate, "max-error-rate", settings.MaxErrorRate, "Allowed share of rejected games in strict mode")
+	flag.StringVar(&settings.Analyze.Variations, "variations", settings.Analyze.Variations, "Positions from evaluated side lines: off, result (labelled with game result), noresult (written with * result)")
+	flag.StringVar(&settings.Analyze.Mate, "mate", settings.Analyze.Mate, "Positions with mate score: drop or keep (converted to centipawns)")
+	flag.IntVar(&settings.Analyze.MateScore, "mate-score", settings.Analyze.MateScore, "Centipawn score of kept mate")
+	flag.IntVar(&settings.Analyze.MateStep, "mate-step", settings.Analyze.MateStep, "Centipawns subtracted from mate score per move to mate (not below mate-score/2)")
+	flag.IntVar(&settings.Analyze.Filters.MinDepth, "min-depth", settings.Analyze.Filters.MinDepth, "Minimum eval depth (0 disables, positions without known depth are not filtered)")
+	flag.IntVar(&settings.Analyze.Filters.MaxDepth, "max-depth", settings.Analyze.Filters.MaxDepth, "Maximum eval depth (0 disables)")
+	flag.IntVar(&settings.Analyze.Filters.MinPly, "min-ply", settings.Analyze.Filters.MinPly, "Minimum ply from initial position, FEN move number is taken into account (0 disables)")
+	flag.IntVar(&settings.Analyze.Filters.MaxPly, "max-ply", settings.Analyze.Filters.MaxPly, "Maximum ply from initial position (0 disables)")
+	flag.IntVar(&settings.Analyze.Filters.SkipPlies, "skip-plies", settings.Analyze.Filters.SkipPlies, "Number of first plies of game to skip")
+	flag.IntVar(&settings.Analyze.Filters.MaxScore, "max-score", settings.Analyze.Filters.MaxScore, "Maximum absolute centipawn score (0 disables)")
+	flag.IntVar(&settings.Analyze.Filters.MaxHalfmove, "max-halfmove", settings.Analyze.Filters.MaxHalfmove, "Maximum halfmove clock (0 disables)")
+	flag.BoolVar(&settings.Analyze.Filters.Check, "filter-check", settings.Analyze.Filters.Check, "Skip positions in check")
+	flag.BoolVar(&settings.Analyze.Filters.Repetition, "filter-repetition", settings.Analyze.Filters.Repetition, "Skip positions repeated in game")
+	flag.BoolVar(&settings.Analyze.Filters.Quiet, "filter-quiet", settings.Analyze.Filters.Quiet, "Skip not quiet positions")
+	flag.Var(newStringList(&settings.Analyze.Filters.TacticalPlayed), "filter-played", "Skip positions where played move is tactical: comma separated capture, promotion, check")
+	flag.Var(newStringList(&settings.Analyze.Filters.TacticalPV), "filter-pv", "Skip positions where the first engine PV move (EPD bm) is tactical: comma separated capture, promotion, check")
+	flag.Float64Var(&settings.Analyze.Filters.MaxDisagreement, "max-disagreement", settings.Analyze.Filters.MaxDisagreement, "Skip positions where expected result 1/(1+exp(-score/consistency-scale)) differs from game result by more than this (0 disables)")
+	flag.Float64Var(&settings.Analyze.Filters.ConsistencyScale, "consistency-scale", settings.Analyze.Filters.ConsistencyScale, "Centipawn scale of sigmoid for max-disagreement")
+	flag.BoolVar(&settings.Analyze.Adjudicate, "adjudicate", settings.Analyze.Adjudicate, "Adjudicate unfinished (*) games by final position or trailing evals instead of skipping them")
+	flag.IntVar(&settings.Analyze.AdjudicateWinScore, "adjudicate-win-score", settings.Analyze.AdjudicateWinScore, "Centipawn score of adjudicated win")
+	flag.IntVar(&settings.Analyze.AdjudicateWinMoves, "adjudicate-win-moves", settings.Analyze.AdjudicateWinMoves, "Number of last moves of both sides with win score to adjudicate win (0 disables)")
+	flag.IntVar(&settings.Analyze.AdjudicateDrawScore, "adjudicate-draw-score", settings.Analyze.AdjudicateDrawScore, "Maximum absolute centipawn score of adjudicated draw")
+	flag.IntVar(&settings.Analyze.AdjudicateDrawMoves, "adjudicate-draw-moves", settings.Analyze.AdjudicateDrawMoves, "Number of last moves of both sides with draw score to adjudicate draw (0 disables)")
+	flag.IntVar(&settings.Analyze.Select.MinElo, "min-elo", settings.Analyze.Select.MinElo, "Minimum WhiteElo and BlackElo (0 disables)")
+	flag.IntVar(&settings.Analyze.Select.MaxElo, "max-elo", settings.Analyze.Select.MaxElo, "Maximum WhiteElo and BlackElo (0 disables)")
+	flag.Var(newStringList(&settings.Analyze.Select.Players), "player", "Regexp of player name, both White and Black must match one of them (repeatable)")
+	flag.Var(newStringList(&settings.Analyze.Select.ExcludePlayers), "exclude-player", "Regexp of player name, games of matching players are skipped (repeatable)")
+	flag.Var(newStringList(&settings.Analyze.Select.Events), "event", "Regexp of Event tag, games of other events are skipped (repeatable)")
+	flag.StringVar(&settings.Analyze.Select.MinDate, "min-date", settings.Analyze.Select.MinDate, "Minimum Date tag: YYYY, YYYY.MM or YYYY.MM.DD")
+	flag.StringVar(&settings.Analyze.Select.MaxDate, "max-date", settings.Analyze.Select.MaxDate, "Maximum Date tag: YYYY, YYYY.MM or YYYY.MM.DD")
+	flag.IntVar(&settings.Analyze.Select.MinPlyCount, "min-ply-count", settings.Analyze.Select.MinPlyCount, "Minimum PlyCount tag or number of main line plies (0 disables)")
+	flag.IntVar(&settings.Analyze.Select.MaxPlyCount, "max-ply-count", settings.Analyze.Select.MaxPlyCount, "Maximum PlyCount tag or number of main line plies (0 disables)")
+	flag.Float64Var(&settings.Analyze.Select.MinTime, "min-time", settings.Analyze.Select.MinTime, "Minimum TimeControl as seconds per side for 40 moves: base for 40 moves + 40 increments (0 disables)")
+	flag.Float64Var(&settings.Analyze.Select.MaxTime, "max-time", settings.Analyze.Select.MaxTime, "Maximum TimeControl as seconds per side for 40 moves (0 disables)")
+	flag.StringVar(&settings.Analyze.GameFilter, "game-filter", settings.Analyze.GameFilter, "Expression selecting games by tags, plies, result, termination, e.g. \"WhiteElo > 3200 && plies >= 40\"")
+	flag.StringVar(&settings.Analyze.PositionFilter, "position-filter", settings.Analyze.PositionFilter, "Expression selecting positions by ply, gameply, score, mate, depth, white, pieces, pawns, knights, bishops, rooks, queens, phase, halfmove, check, repetitions, result and tags, e.g. \"ply >= 16 && abs(score) < 800\"")
+	flag.Int64Var(&settings.Analyze.Sample.Seed, "seed", settings.Analyze.Sample.Seed, "Seed of position sampling, balancing and dedup")
+	flag.Float64Var(&settings.Analyze.Sample.Rate, "sample-rate", settings.Analyze.Sample.Rate, "Probability to keep position of game (0 or 1 keeps all)")
+	flag.IntVar(&settings.Analyze.Sample.PerGame, "sample-per-game", settings.Analyze.Sample.PerGame, "Maximum number of positions per game (0 disables)")
+	flag.BoolVar(&settings.Analyze.Sample.ByPhase, "sample-by-phase", settings.Analyze.Sample.ByPhase, "Take sample-per-game positions evenly from opening, middlegame and endgame")
+	flag.BoolVar(&settings.DuplicateGames.Enabled, "dedup-games", settings.DuplicateGames.Enabled, "Skip repeated games of all PGN inputs before analysis, duplicates are reported by files")
+	flag.Var(newStringList(&settings.DuplicateGames.Tags), "dedup-games-tags", fmt.Sprintf("Tags identifying game with its moves and FEN tag for dedup-games (repeatable, default %v)", strings.Join(defaultDuplicateTags, ",")))
+	flag.StringVar(&settings.Dedup.Policy, "dedup", settings.Dedup.Policy, "Remove duplicate positions across games: first (streaming Bloom filter, may drop rare unique positions), random or average (score and result of duplicates)")
+	flag.BoolVar(&settings.Dedup.Flip, "dedup-flip", settings.Dedup.Flip, "Colour-flipped positions are duplicates")
+	flag.IntVar(&settings.Dedup.MemoryMB, "dedup-memory", settings.Dedup.MemoryMB, "Memory in MB for Bloom filter or sorted runs of dedup")
+	flag.StringVar(&settings.Dedup.TempDir, "dedup-temp", settings.Dedup.TempDir, "Folder for sorted runs of dedup (system temp folder by default)")
+	flag.StringVar(&settings.Balance.Key, "balance", settings.Balance.Key, fmt.Sprintf("Balance output by buckets: %v (king is side to move king square)", strings.Join(balanceKeys, ", ")))
+	flag.IntVar(&settings.Balance.Quota, "balance-quota", settings.Balance.Quota, "Maximum number of positions per bucket (0 disables)")
+	flag.IntVar(&settings.Balance.Total, "balance-total", settings.Balance.Total, "Number of output positions divided by balance-proportions")
+	flag.Var(newStringList(&settings.Balance.Proportions), "balance-proportions", "Target share of bucket as bucket=weight, * for other buckets (repeatable)")
+	flag.Var(newStringList(&settings.Analyze.Terminations), "termination", fmt.Sprintf("Action for games by termination class: class=action, class is one of %v, action is keep, drop or adjudicate (repeatable)", strings.Join(terminationClasses[1:], ", ")))
+	flag.BoolVar(&settings.Analyze.SkipBook, "skip-book", settings.Analyze.SkipBook, "Skip book moves: commented {book}, with depth 1 zero time pseudo evals or leading to book-file positions")
+	flag.StringVar(&settings.Analyze.BookFile, "book-file", settings.Analyze.BookFile, "EPD or FEN list of opening book positions for skip-book (Polyglot .bin books are not supported)")
+	flag.BoolVar(&settings.Analyze.BookUntilEval, "book-until-eval", settings.Analyze.BookUntilEval, "With skip-book, moves before the first engine eval of game are book moves")
+	flag.IntVar(&settings.Analyze.BookSkipPlies, "book-skip-plies", settings.Analyze.BookSkipPlies, "With skip-book, number of plies after book to skip")
+	flag.Var(newStringList(&settings.Analyze.CommentFormats), "comments", fmt.Sprintf("Eval comment format (%v) for all files or glob=format for matching files (repeatable)", strings.Join(commentFormatNames(), ", ")))
 	flag.Parse()
 
+	if flag.NArg() != 0 {
+		inputs.Append(flag.Args()...)
+	}
+	if len(settings.Inputs) == 0 && len(settings.Datasets) == 0 {
+		settings.Inputs = []string{defaultInput}
+	}
+
 	log.Printf("%+v", settings)
 
-	pgnFiles, err := pgnFiles(settings.GamesFolder)
+	if settings.Castling != CastlingXFEN && settings.Castling != CastlingShredder {
+		return fmt.Errorf("bad castling format %v", settings.Castling)
+	}
+
+	comments, err := NewCommentSelector(settings.Analyze.CommentFormats)
+	if err != nil {
+		return err
+	}
+
+	if settings.Analyze.Mate != MateDrop && settings.Analyze.Mate != MateKeep {
+		return fmt.Errorf("bad mate mode %v", settings.Analyze.Mate)
+	}
+
+	err = validateTerminationRules(settings.Analyze.Terminations)
+	if err != nil {
+		return err
+	}
+
+	err = settings.Analyze.Select.compile()
+	if err != nil {
+		return err
+	}
+	err = settings.Analyze.compileExpressions()
+	if err != nil {
+		return err
+	}
+
+	if settings.Analyze.Sample.Rate < 0 || settings.Analyze.Sample.Rate > 1 {
+		return fmt.Errorf("bad sample rate %v", settings.Analyze.Sample.Rate)
+	}
+
+	err = settings.Dedup.validate()
+	if err != nil {
+		return err
+	}
+	err = settings.Balance.validate()
+	if err != nil {
+		return err
+	}
+
+	if settings.Analyze.Filters.MaxDisagreement != 0 && settings.Analyze.Filters.ConsistencyScale <= 0 {
+		return fmt.Errorf("bad consistency scale %v", settings.Analyze.Filters.ConsistencyScale)
+	}
+
+	err = validateTacticalCategories(settings.Analyze.Filters.TacticalPlayed)
+	if err != nil {
+		return err
+	}
+	err = validateTacticalCategories(settings.Analyze.Filters.TacticalPV)
+	if err != nil {
+		return err
+	}
+
+	switch settings.Analyze.Variations {
+	case VariationsOff, VariationsResult, VariationsNoResult:
+	default:
+		return fmt.Errorf("bad variations mode %v", settings.Analyze.Variations)
+	}
+
+	if settings.Analyze.BookFile != "" {
+		settings.Analyze.book, err = LoadOpeningBook(settings.Analyze.BookFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	inputFiles, err := findInputFiles(settings.Inputs, settings.InputFilter, isInputFile)
 	if err != nil {
 		return err
 	}
-	if len(pgnFiles) == 0 {
-		return fmt.Errorf("At least one PGN file is expected")
+	datasetFiles, err := findInputFiles(settings.Datasets, settings.InputFilter, isDatasetFile)
+	if err != nil {
+		return err
+	}
+	if len(inputFiles) == 0 && len(datasetFiles) == 0 {
+		return fmt.Errorf("At least one PGN, EPD or dataset file is expected")
+	}
+	for _, file := range datasetFiles {
+		if sameFile(file, settings.ResultPath) {
+			return fmt.Errorf("dataset %v is the output file", file)
+		}
+	}
+
+	var pgnFiles, epdFiles []string
+	for _, file := range inputFiles {
+		if isEpdFile(file) {
+			epdFiles = append(epdFiles, file)
+		} else {
+			pgnFiles = append(pgnFiles, file)
+		}
+	}
+
+	var stats = NewStats()
+	reporter, err := NewErrorReporter(settings.Quarantine, stats)
+	if err != nil {
+		return err
 	}
+	defer reporter.Close()
+	reporter.Strict = settings.Strict
+	reporter.MaxErrorRate = settings.MaxErrorRate
 
-	return fengenPipeline(context.Background(), QuietServiceBuilder, settings.Threads, pgnFiles, settings.ResultPath)
+	err = fengenPipeline(context.Background(), QuietServiceBuilder, settings, comments, stats, reporter, pgnFiles, epdFiles, datasetFiles)
+	stats.Log()
+	if err != nil {
+		return err
+	}
+	err = reporter.Check()
+	if err != nil {
+		return err
+	}
+	return writeMetadata(settings.ResultPath, settings, stats)
 }
 
 func fengenPipeline(
 	ctx context.Context,
 	quietServiceBuilder func() IQuietService, //for each thread
-	threads int,
+	settings Settings,
+	comments *CommentSelector,
+	stats *Stats,
+	reporter *ErrorReporter,
 	pgnFiles []string,
-	resultPath string,
+	epdFiles []string,
+	datasetFiles []string,
 ) error {
 
 	log.Println("fengen started")
@@ -89,25 +317,71 @@ func fengenPipeline(
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	var pgns = make(chan string, 128)
+	var pgns = make(chan Pgn, 128)
+	var lines = make(chan LineBatch, 16)
 	var games = make(chan []PositionInfo, 128)
 
 	g.Go(func() error {
 		defer close(pgns)
-		return LoadPgnsManyFiles(ctx, pgnFiles, pgns)
+		if settings.Readers <= 1 {
+			return LoadPgnsManyFiles(ctx, pgnFiles, pgns)
+		}
+		return LoadPgnsParallel(ctx, pgnFiles, pgns, settings.Readers, int64(settings.ChunkSizeMB)<<20)
 	})
 
+	var analyzed <-chan Pgn = pgns
+	if settings.DuplicateGames.Enabled {
+		var unique = make(chan Pgn, 128)
+		analyzed = unique
+		g.Go(func() error {
+			defer close(unique)
+			return dedupGames(ctx, settings.DuplicateGames, stats, pgns, unique)
+		})
+	}
+
 	g.Go(func() error {
-		return saveFens(ctx, games, resultPath)
+		defer close(lines)
+		var err = LoadLinesManyFiles(ctx, epdFiles, FormatEpd, lines)
+		if err != nil {
+			return err
+		}
+		return LoadLinesManyFiles(ctx, datasetFiles, FormatDataset, lines)
+	})
+
+	var output = games
+	if settings.Dedup.enabled() {
+		var input, unique = output, make(chan []PositionInfo, 128)
+		output = unique
+		g.Go(func() error {
+			defer close(unique)
+			return dedupPositions(ctx, settings.Dedup, settings.Analyze.Sample.Seed, stats, input, unique)
+		})
+	}
+	if settings.Balance.enabled() {
+		var input, balanced = output, make(chan []PositionInfo, 128)
+		output = balanced
+		g.Go(func() error {
+			defer close(balanced)
+			return balancePositions(ctx, settings.Balance, settings.Analyze.Sample.Seed, stats, input, balanced)
+		})
+	}
+
+	g.Go(func() error {
+		return saveFens(ctx, output, settings.ResultPath, settings.Castling)
 	})
 
 	var wg = &sync.WaitGroup{}
 
-	for i := 0; i < threads; i++ {
+	for i := 0; i < settings.Threads; i++ {
 		wg.Add(1)
 		g.Go(func() error {
 			defer wg.Done()
-			return analyzeGames(ctx, quietServiceBuilder(), pgns, games)
+			var quietService = quietServiceBuilder()
+			var err = analyzeGames(ctx, quietService, &settings.Analyze, comments, stats, reporter, analyzed, games)
+			if err != nil {
+				return err
+			}
+			return analyzeLines(ctx, quietService, &settings.Analyze, stats, lines, games)
 		})
 	}
 
@@ -120,18 +394,11 @@ func fengenPipeline(
 	return g.Wait()
 }
 
-func pgnFiles(folderPath string) ([]string, error) {
-	files, err := ioutil.ReadDir(folderPath)
-	if err != nil {
-		return nil, err
-	}
-	var result []string
-	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".pgn" {
-			result = append(result, filepath.Join(folderPath, file.Name()))
-		}
-	}
-	return result, nil
+// sameFile checks if both paths refer to existing file.
+func sameFile(path1, path2 string) bool {
+	var stat1, err1 = os.Stat(path1)
+	var stat2, err2 = os.Stat(path2)
+	return err1 == nil && err2 == nil && os.SameFile(stat1, stat2)
 }
 
 func max(a, b int) int {
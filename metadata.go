@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const metadataSuffix = ".meta.json"
+
+// writeMetadata saves settings used to produce dataset next to the output file,
+// so that the choices (mate conversion, filters, etc.) can be recovered later.
+func writeMetadata(resultPath string, settings Settings, stats *Stats) error {
+	var metadata = struct {
+		Created  time.Time
+		Settings Settings
+		Stats    map[string]int64
+	}{
+		Created:  time.Now(),
+		Settings: settings,
+		Stats:    stats.Counts(),
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(resultPath+metadataSuffix, data, 0644)
+}
+
+// isDatasetFile accepts any file in dataset folders except metadata.
+func isDatasetFile(name string) bool {
+	return !strings.HasSuffix(name, metadataSuffix)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+const sampleStatsName = "sample: dropped"
+
+// SampleSettings reduces number of correlated positions taken from one game.
+// Decisions depend on seed, game text and position only, so the same inputs and seed
+// produce the same dataset with any number of threads.
+type SampleSettings struct {
+	Seed int64
+	// Rate is probability to keep position, 0 or 1 keeps all.
+	Rate float64
+	// PerGame is maximum number of positions per game, 0 disables.
+	PerGame int
+	// ByPhase takes PerGame positions evenly from opening, middlegame and endgame of game.
+	ByPhase bool
+}
+
+func (s *SampleSettings) enabled() bool {
+	return s.Rate > 0 && s.Rate < 1 || s.PerGame > 0
+}
+
+// sampleGame returns sampled positions of game in original order.
+func (s *SampleSettings) sampleGame(positions []PositionInfo, pgn string, stats *Stats) []PositionInfo {
+	if !s.enabled() || len(positions) == 0 {
+		return positions
+	}
+	var hasher = fnv.New64a()
+	hasher.Write([]byte(pgn))
+	var gameHash = hasher.Sum64()
+
+	type sample struct {
+		index int
+		hash  uint64
+	}
+	var samples = make([]sample, 0, len(positions))
+	for i := range positions {
+		var hash = sampleHash(s.Seed, gameHash, positions[i].position.Key)
+		if s.Rate > 0 && s.Rate < 1 && hashFraction(hash) >= s.Rate {
+			continue
+		}
+		samples = append(samples, sample{index: i, hash: hash})
+	}
+
+	if s.PerGame > 0 && len(samples) > s.PerGame {
+		// positions with the smallest hashes are taken, by phase in turns
+		sort.Slice(samples, func(i, j int) bool { return samples[i].hash < samples[j].hash })
+		if s.ByPhase {
+			var byPhase = make(map[string][]sample)
+			for _, item := range samples {
+				var phase = gamePhase(&positions[item.index].position)
+				byPhase[phase] = append(byPhase[phase], item)
+			}
+			samples = samples[:0]
+			for round := 0; len(samples) < s.PerGame; round++ {
+				for _, phase := range []string{PhaseOpening, PhaseMiddlegame, PhaseEndgame} {
+					if round < len(byPhase[phase]) && len(samples) < s.PerGame {
+						samples = append(samples, byPhase[phase][round])
+					}
+				}
+			}
+		} else {
+			samples = samples[:s.PerGame]
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].index < samples[j].index })
+	}
+
+	stats.Add(sampleStatsName, int64(len(positions)-len(samples)))
+	var result = make([]PositionInfo, len(samples))
+	for i, item := range samples {
+		result[i] = positions[item.index]
+	}
+	return result
+}
+
+func sampleHash(seed int64, gameHash, key uint64) uint64 {
+	return splitMix64(splitMix64(uint64(seed)^gameHash) ^ key)
+}
+
+// hashFraction maps hash to [0, 1).
+func hashFraction(hash uint64) float64 {
+	return float64(hash>>11) / (1 << 53)
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+func TestSample(t *testing.T) {
+	var fens = []string{
+		common.InitialPositionFen,
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+		"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2",
+		"r4rk1/pp3ppp/2n5/8/8/2N5/PP3PPP/R4RK1 b - - 0 20",
+		"r4rk1/pp3ppp/2n5/8/8/2N5/PP3PPP/R4RK1 w - - 1 21",
+		"r4rk1/pp3ppp/8/8/8/2N5/PP3PPP/R4RK1 b - - 0 22",
+		"4k3/4p3/8/8/8/8/4P3/4K2R b - - 0 40",
+		"4k3/4p3/8/8/8/8/4P3/4K2R w - - 1 41",
+		"4k3/4p3/8/8/8/8/4P3/5K1R b - - 2 41",
+		"4k3/8/4p3/8/8/8/4P3/5K1R w - - 0 42",
+	}
+	var positions []PositionInfo
+	for _, fen := range fens {
+		var p, err = common.NewPositionFromFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, PositionInfo{position: p})
+	}
+
+	var fenSet = func(positions []PositionInfo) []string {
+		var result []string
+		for i := range positions {
+			result = append(result, positions[i].position.String())
+		}
+		return result
+	}
+
+	var s = &SampleSettings{Seed: 1, PerGame: 3, ByPhase: true}
+	var stats = NewStats()
+	var sampled = s.sampleGame(positions, "game 1", stats)
+	if len(sampled) != 3 || stats.Counts()[sampleStatsName] != 7 {
+		t.Fatalf("got %v %v", len(sampled), stats.Counts())
+	}
+	var phases = make(map[string]bool)
+	for i := range sampled {
+		phases[gamePhase(&sampled[i].position)] = true
+	}
+	if len(phases) != 3 {
+		t.Errorf("got phases %v", phases)
+	}
+	if again := s.sampleGame(positions, "game 1", nil); !reflect.DeepEqual(fenSet(again), fenSet(sampled)) {
+		t.Error("sampling is not reproducible")
+	}
+
+	var differs = false
+	for seed := int64(2); seed < 10 && !differs; seed++ {
+		var other = &SampleSettings{Seed: seed, PerGame: 3}
+		differs = !reflect.DeepEqual(fenSet(other.sampleGame(positions, "game 1", nil)), fenSet(sampled))
+	}
+	if !differs {
+		t.Error("sample does not depend on seed")
+	}
+
+	var kept int
+	for game := 0; game < 100; game++ {
+		kept += len((&SampleSettings{Seed: 1, Rate: 0.3}).sampleGame(positions, string(rune('A'+game)), nil))
+	}
+	if kept < 200 || kept > 400 {
+		t.Errorf("kept %v of 1000 positions with rate 0.3", kept)
+	}
+
+	if all := (&SampleSettings{Seed: 1}).sampleGame(positions, "game 1", nil); len(all) != len(positions) {
+		t.Errorf("got %v positions without sampling", len(all))
+	}
+}
@@ -11,8 +11,12 @@ import (
 	"github.com/ChizhovVadim/CounterGo/common"
 )
 
+// NoGameResult marks positions without known game result, written as "*".
+const NoGameResult float32 = -1
+
 type PositionInfo struct {
 	position   common.Position
+	castling   Castling
 	score      int
 	gameResult float32
 }
@@ -21,6 +25,7 @@ func saveFens(
 	ctx context.Context,
 	games <-chan []PositionInfo,
 	filepath string,
+	castlingFormat string,
 ) error {
 	file, err := os.Create(filepath)
 	if err != nil {
@@ -49,7 +54,7 @@ LOOP:
 			if !gameOk {
 				break LOOP
 			}
-			err = writeGame(file, game)
+			err = writeGame(file, game, castlingFormat)
 			if err != nil {
 				return err
 			}
@@ -62,19 +67,23 @@ LOOP:
 	return nil
 }
 
-func writeGame(w io.Writer, game []PositionInfo) error {
+func writeGame(w io.Writer, game []PositionInfo, castlingFormat string) error {
 	for i := range game {
 		var item = &game[i]
-		var fen = item.position.String()
+		var fen = formatFEN(&item.position, item.castling, castlingFormat)
 		var score = item.score
 		// score from white point of view
 		if !item.position.WhiteMove {
 			score = -score
 		}
+		var gameResult interface{} = item.gameResult
+		if item.gameResult == NoGameResult {
+			gameResult = GameResultNone
+		}
 		var _, err = fmt.Fprintf(w, "%v;%v;%v\n",
 			fen,
 			score,
-			item.gameResult)
+			gameResult)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const selectionStatsPrefix = "selection: "
+
+// GameSelection selects games by tags before positions are analyzed. Zero limit disables the check,
+// game without tag is skipped by enabled check. Name patterns are regular expressions
+// matching part of the name unless anchored.
+type GameSelection struct {
+	MinElo         int      // both players
+	MaxElo         int      // both players
+	Players        []string // both players must match one of patterns
+	ExcludePlayers []string // game is skipped if any player matches one of patterns
+	Events         []string // Event must match one of patterns
+	MinDate        string   // YYYY, YYYY.MM or YYYY.MM.DD
+	MaxDate        string
+	MinPlyCount    int // PlyCount tag, number of main line plies if there is no tag
+	MaxPlyCount    int
+	// MinTime and MaxTime limit TimeControl estimated as seconds per side for game of 40 moves:
+	// base time for 40 moves plus 40 increments.
+	MinTime float64
+	MaxTime float64
+
+	active         bool
+	players        []*regexp.Regexp
+	excludePlayers []*regexp.Regexp
+	events         []*regexp.Regexp
+}
+
+// compile validates settings and compiles patterns, it is called once before games are analyzed.
+func (s *GameSelection) compile() error {
+	var err error
+	if s.players, err = compilePatterns(s.Players); err != nil {
+		return err
+	}
+	if s.excludePlayers, err = compilePatterns(s.ExcludePlayers); err != nil {
+		return err
+	}
+	if s.events, err = compilePatterns(s.Events); err != nil {
+		return err
+	}
+	for _, date := range []string{s.MinDate, s.MaxDate} {
+		if date != "" && !isDatePrefix(normalizeDate(date)) {
+			return fmt.Errorf("bad date %v, expected YYYY, YYYY.MM or YYYY.MM.DD", date)
+		}
+	}
+	s.active = s.MinElo != 0 || s.MaxElo != 0 || len(s.players) != 0 || len(s.excludePlayers) != 0 ||
+		len(s.events) != 0 || s.MinDate != "" || s.MaxDate != "" || s.MinPlyCount != 0 || s.MaxPlyCount != 0 ||
+		s.MinTime != 0 || s.MaxTime != 0
+	return nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var result []*regexp.Regexp
+	for _, pattern := range patterns {
+		var re, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// selectTags returns reason why game is skipped or empty string.
+// Ply count is checked only if there is PlyCount tag.
+func (s *GameSelection) selectTags(tags []Tag) string {
+	if s.MinElo != 0 || s.MaxElo != 0 {
+		for _, key := range []string{"WhiteElo", "BlackElo"} {
+			var value, _ = tagValue(tags, key)
+			var elo, err = strconv.Atoi(value)
+			if err != nil || s.MinElo != 0 && elo < s.MinElo || s.MaxElo != 0 && elo > s.MaxElo {
+				return "elo"
+			}
+		}
+	}
+
+	if len(s.players) != 0 || len(s.excludePlayers) != 0 {
+		for _, key := range []string{"White", "Black"} {
+			var name, _ = tagValue(tags, key)
+			if len(s.players) != 0 && !matchAny(s.players, name) || matchAny(s.excludePlayers, name) {
+				return "player"
+			}
+		}
+	}
+
+	if len(s.events) != 0 {
+		var event, _ = tagValue(tags, "Event")
+		if !matchAny(s.events, event) {
+			return "event"
+		}
+	}
+
+	if s.MinDate != "" || s.MaxDate != "" {
+		var date, _ = tagValue(tags, "Date")
+		date = normalizeDate(date)
+		if !inDateRange(date, normalizeDate(s.MinDate), normalizeDate(s.MaxDate)) {
+			return "date"
+		}
+	}
+
+	if plyCount, found := tagValue(tags, "PlyCount"); found && (s.MinPlyCount != 0 || s.MaxPlyCount != 0) {
+		var plies, err = strconv.Atoi(plyCount)
+		if err != nil {
+			return "ply count"
+		}
+		if reason := s.selectPlies(plies); reason != "" {
+			return reason
+		}
+	}
+
+	if s.MinTime != 0 || s.MaxTime != 0 {
+		var timeControl, _ = tagValue(tags, "TimeControl")
+		var seconds, ok = parseTimeControl(timeControl)
+		if !ok || s.MinTime != 0 && seconds < s.MinTime || s.MaxTime != 0 && seconds > s.MaxTime {
+			return "time control"
+		}
+	}
+	return ""
+}
+
+func (s *GameSelection) selectPlies(plies int) string {
+	if s.MinPlyCount != 0 && plies < s.MinPlyCount || s.MaxPlyCount != 0 && plies > s.MaxPlyCount {
+		return "ply count"
+	}
+	return ""
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDate converts date separators to PGN dots, e.g. 2021-10-06 to 2021.10.06.
+func normalizeDate(date string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(date, "-", "."), "/", ".")
+}
+
+func isDatePrefix(date string) bool {
+	var parts = strings.Split(date, ".")
+	if len(parts) > 3 || len(parts[0]) != 4 {
+		return false
+	}
+	for i, part := range parts {
+		if i > 0 && len(part) != 2 || !isDigits(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// inDateRange compares date with limits of the same precision, date with unknown part (??) is out of range.
+func inDateRange(date, first, last string) bool {
+	for _, limit := range []string{first, last} {
+		if limit == "" {
+			continue
+		}
+		if len(date) < len(limit) || !isDatePrefix(date[:len(limit)]) {
+			return false
+		}
+	}
+	return (first == "" || date[:len(first)] >= first) && (last == "" || date[:len(last)] <= last)
+}
+
+// parseTimeControl estimates PGN TimeControl as seconds per side for game of 40 moves.
+// Periods are separated by ':', only the first one is taken: moves/seconds, seconds+increment or *seconds.
+func parseTimeControl(timeControl string) (float64, bool) {
+	var period = strings.TrimSpace(strings.SplitN(timeControl, ":", 2)[0])
+	if strings.HasPrefix(period, "*") {
+		var seconds, err = strconv.ParseFloat(period[1:], 64)
+		return seconds, err == nil
+	}
+	var moves = 40.0
+	if index := strings.IndexByte(period, '/'); index >= 0 {
+		var err error
+		moves, err = strconv.ParseFloat(period[:index], 64)
+		if err != nil || moves <= 0 {
+			return 0, false
+		}
+		period = period[index+1:]
+	}
+	var increment float64
+	if index := strings.IndexByte(period, '+'); index >= 0 {
+		var err error
+		increment, err = strconv.ParseFloat(period[index+1:], 64)
+		if err != nil {
+			return 0, false
+		}
+		period = period[:index]
+	}
+	var base, err = strconv.ParseFloat(period, 64)
+	if err != nil {
+		return 0, false
+	}
+	return base*40/moves + 40*increment, true
+}
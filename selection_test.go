@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGameSelection(t *testing.T) {
+	const tags = `[Event "CCRL 40/15"]
+[Date "2021.10.06"]
+[White "Demolito 2021-07-09 64-bit"]
+[Black "Counter 4.0 64-bit"]
+[Result "1/2-1/2"]
+[WhiteElo "3101"]
+[BlackElo "2991"]
+[TimeControl "40/900:40/900"]
+`
+	for _, test := range []struct {
+		selection GameSelection
+		reason    string
+	}{
+		{GameSelection{}, ""},
+		{GameSelection{MinElo: 2900, MaxElo: 3200}, ""},
+		{GameSelection{MinElo: 3000}, "elo"},
+		{GameSelection{Players: []string{"^Counter", "^Demolito"}}, ""},
+		{GameSelection{Players: []string{"^Counter"}}, "player"},
+		{GameSelection{ExcludePlayers: []string{"Demolito"}}, "player"},
+		{GameSelection{Events: []string{"^CCRL 40/15"}}, ""},
+		{GameSelection{Events: []string{"Blitz"}}, "event"},
+		{GameSelection{MinDate: "2021", MaxDate: "2021-10-06"}, ""},
+		{GameSelection{MinDate: "2021.11"}, "date"},
+		{GameSelection{MaxDate: "2020"}, "date"},
+		{GameSelection{MinPlyCount: 20, MaxPlyCount: 30}, ""},
+		{GameSelection{MinPlyCount: 21}, "ply count"},
+		{GameSelection{MinTime: 900}, ""},
+		{GameSelection{MaxTime: 600}, "time control"},
+	} {
+		var selection = test.selection
+		if err := selection.compile(); err != nil {
+			t.Fatal(err)
+		}
+		var settings = &AnalyzeSettings{Select: selection}
+		var stats = NewStats()
+		var positions, err = AnalyzeGame(&AllQuietService{}, settings, nil,
+			tags+"\n1. e4 {+0.30/20} e5 {-0.30/20} 2. Nf3 {+0.30/20} Nc6 {-0.30/20} 3. Bb5 {+0.30/20} a6 {-0.30/20} "+
+				"4. Ba4 {+0.30/20} Nf6 {-0.30/20} 5. O-O {+0.30/20} Be7 {-0.30/20} "+
+				"6. Re1 {+0.30/20} b5 {-0.30/20} 7. Bb3 {+0.30/20} d6 {-0.30/20} 8. c3 {+0.30/20} O-O {-0.30/20} "+
+				"9. h3 {+0.30/20} Nb8 {-0.30/20} 10. d4 {+0.30/20} Nbd7 {-0.30/20} 1/2-1/2", stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var skipped = len(positions) == 0
+		if skipped != (test.reason != "") || skipped && stats.Counts()[selectionStatsPrefix+test.reason] != 1 {
+			t.Errorf("%+v: got %v positions, %v", test.selection, len(positions), stats.Counts())
+		}
+	}
+
+	if err := (&GameSelection{MinDate: "10.06.2021"}).compile(); err == nil {
+		t.Error("bad date accepted")
+	}
+	for _, test := range []struct {
+		timeControl string
+		seconds     float64
+		ok          bool
+	}{
+		{"40/900", 900, true},
+		{"300+3", 420, true},
+		{"60+0.6", 84, true},
+		{"1/30", 1200, true},
+		{"*180", 180, true},
+		{"-", 0, false},
+		{"?", 0, false},
+	} {
+		if seconds, ok := parseTimeControl(test.timeControl); seconds != test.seconds || ok != test.ok {
+			t.Errorf("%v: got %v %v", test.timeControl, seconds, ok)
+		}
+	}
+}
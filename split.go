@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const gameStartTag = "[Event"
+
+type pgnChunk struct {
+	filepath   string
+	start, end int64
+	progress   *fileProgress
+}
+
+// LoadPgnsParallel reads several files concurrently and splits large plain PGN files
+// into byte ranges of chunkSize, each read by own goroutine.
+// Every game is sent to pgns exactly once, but order of games is not preserved.
+func LoadPgnsParallel(ctx context.Context, files []string, pgns chan<- Pgn,
+	readers int, chunkSize int64) error {
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	var chunks = make(chan pgnChunk)
+
+	g.Go(func() error {
+		defer close(chunks)
+		for _, filepath := range files {
+			var fileChunks, err = splitPgnFile(filepath, chunkSize)
+			if err != nil {
+				return err
+			}
+			for _, chunk := range fileChunks {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case chunks <- chunk:
+				}
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < readers; i++ {
+		g.Go(func() error {
+			for chunk := range chunks {
+				var err error
+				if chunk.progress == nil {
+					err = LoadPgns(ctx, chunk.filepath, pgns)
+				} else {
+					err = loadPgnChunk(ctx, chunk, pgns)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// splitPgnFile returns byte ranges of plain PGN file.
+// Compressed files and stdin can not be split and are read as a whole.
+func splitPgnFile(filepath string, chunkSize int64) ([]pgnChunk, error) {
+	if filepath == stdinInput || compressionExt(filepath) != "" || chunkSize <= 0 {
+		return []pgnChunk{{filepath: filepath}}, nil
+	}
+	stat, err := os.Stat(filepath)
+	if err != nil {
+		return nil, err
+	}
+	var size = stat.Size()
+	if !stat.Mode().IsRegular() || size <= chunkSize {
+		return []pgnChunk{{filepath: filepath}}, nil
+	}
+	var progress = newFileProgress(filepath, size)
+	var result []pgnChunk
+	for start := int64(0); start < size; start += chunkSize {
+		result = append(result, pgnChunk{
+			filepath: filepath,
+			start:    start,
+			end:      start + chunkSize,
+			progress: progress,
+		})
+	}
+	return result, nil
+}
+
+// loadPgnChunk sends games which start inside [chunk.start, chunk.end).
+// Chunk boundaries are resynchronized on "[Event" tag after blank line or game result,
+// so the last game is read past chunk end until the next game start.
+// Line numbers are unknown for chunks, games are located by byte offset.
+func loadPgnChunk(ctx context.Context, chunk pgnChunk, pgns chan<- Pgn) error {
+	file, err := os.Open(chunk.filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var pos = chunk.start
+	var prevLine string
+	// chunks overlap by the last game, progress counts bytes of chunk range only
+	var r = bufio.NewReaderSize(&progressReader{
+		r:        io.NewSectionReader(file, chunk.start, 1<<62),
+		progress: chunk.progress,
+		limit:    chunk.end - chunk.start,
+	}, 1<<16)
+
+	if chunk.start > 0 {
+		var partial, err = readPartialLine(file, r, chunk.start)
+		if err != nil {
+			return err
+		}
+		pos += int64(len(partial))
+		prevLine, err = readPrevLine(file, pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	var splitter = &pgnSplitter{file: chunk.filepath}
+	var inGame = chunk.start == 0
+
+	var send = func(pgn Pgn) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case pgns <- pgn:
+			return nil
+		}
+	}
+
+	for {
+		var line, err = r.ReadString('\n')
+		if len(line) == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		var lineStart = pos
+		pos += int64(len(line))
+		if isGameBoundary(prevLine, line) {
+			if lineStart >= chunk.end {
+				break
+			}
+			inGame = true
+		}
+		if inGame {
+			if pgn, ok := splitter.Add(line, 0, lineStart); ok {
+				if err := send(pgn); err != nil {
+					return err
+				}
+			}
+		}
+		prevLine = line
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+	}
+
+	if pgn, ok := splitter.Flush(); ok {
+		return send(pgn)
+	}
+	return nil
+}
+
+// readPartialLine skips rest of line containing offset start-1.
+func readPartialLine(file *os.File, r *bufio.Reader, start int64) (string, error) {
+	var prev [1]byte
+	if _, err := file.ReadAt(prev[:], start-1); err != nil {
+		return "", err
+	}
+	if prev[0] == '\n' {
+		return "", nil
+	}
+	var line, err = r.ReadString('\n')
+	if err == io.EOF {
+		err = nil
+	}
+	return line, err
+}
+
+// readPrevLine reads backward line ending right before offset lineStart.
+func readPrevLine(file *os.File, lineStart int64) (string, error) {
+	// skip '\n' of previous line
+	var end = lineStart - 1
+	var pos = end
+	var buf [256]byte
+	for pos > 0 {
+		var from = pos - int64(len(buf))
+		if from < 0 {
+			from = 0
+		}
+		var n, err = file.ReadAt(buf[:pos-from], from)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if index := bytes.LastIndexByte(buf[:n], '\n'); index >= 0 {
+			pos = from + int64(index) + 1
+			break
+		}
+		pos = from
+	}
+	var line = make([]byte, end-pos)
+	var _, err = file.ReadAt(line, pos)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(line), nil
+}
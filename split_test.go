@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadPgnsParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fengen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sb strings.Builder
+	for i := 0; i < 50; i++ {
+		var game = strings.Replace(pgn, "792.1.311", strings.Repeat("1", i), 1)
+		switch i % 4 {
+		case 0:
+			game = strings.TrimSpace(game) + "\n"
+		case 1:
+			game = strings.Replace(game, "\n", "\r\n", -1)
+		case 2:
+			game = strings.Replace(strings.Replace(game, "\n\n", "\n", 1), "\n", " ", 60)
+		}
+		sb.WriteString(game)
+	}
+	var filepath = filepath.Join(dir, "games.pgn")
+	err = ioutil.WriteFile(filepath, []byte(sb.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = loadAll(t, func(pgns chan<- Pgn) error {
+		return LoadPgns(context.Background(), filepath, pgns)
+	})
+	if len(expected) != 50 {
+		t.Fatalf("expected 50 games, got %v", len(expected))
+	}
+
+	for _, chunkSize := range []int64{37, 100, 777, 4096, 1 << 20} {
+		var actual = loadAll(t, func(pgns chan<- Pgn) error {
+			return LoadPgnsParallel(context.Background(), []string{filepath}, pgns, 4, chunkSize)
+		})
+		if strings.Join(actual, "") != strings.Join(expected, "") {
+			t.Fatalf("chunk size %v: got %v games, expected %v", chunkSize, len(actual), len(expected))
+		}
+	}
+
+	// progress of chunks read past their ends does not exceed file size
+	chunks, err := splitPgnFile(filepath, 777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadAll(t, func(pgns chan<- Pgn) error {
+		for _, chunk := range chunks {
+			if err := loadPgnChunk(context.Background(), chunk, pgns); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if read := chunks[0].progress.read; read != int64(sb.Len()) {
+		t.Errorf("progress: read %v of %v", read, sb.Len())
+	}
+}
+
+func TestPgnSplitter(t *testing.T) {
+	var longMovetext = strings.Repeat("1. e4 {+0.00/1 0s} ", 10000)
+	var text = utf8BOM + `[Event "1"]
+[Result "1-0"]
+1. e4 {comment
+[%eval 0.3] at line start} 1-0
+  [Event "2"]
+
+` + longMovetext + `*
+% escape line
+[Event "3"]
+`
+	var splitter = &pgnSplitter{file: "test.pgn"}
+	var games []Pgn
+	for i, line := range strings.SplitAfter(text, "\n") {
+		if pgn, ok := splitter.Add(line, i+1, 0); ok {
+			games = append(games, pgn)
+		}
+	}
+	if pgn, ok := splitter.Flush(); ok {
+		games = append(games, pgn)
+	}
+	if len(games) != 3 {
+		t.Fatalf("got %v games", len(games))
+	}
+	for i, line := range []int{1, 5, 9} {
+		if games[i].Line != line || games[i].Index != i+1 {
+			t.Fatalf("game %v: got line %v index %v", i+1, games[i].Line, games[i].Index)
+		}
+	}
+	if !strings.HasPrefix(games[0].Text, "[Event") || !strings.HasPrefix(games[1].Text, "[Event") {
+		t.Fatalf("bad game text %q", games[0].Text)
+	}
+	if strings.Contains(games[1].Text, "%") {
+		t.Fatal("escape line between games is attached to game")
+	}
+}
+
+func loadAll(t *testing.T, load func(pgns chan<- Pgn) error) []string {
+	var pgns = make(chan Pgn)
+	var result []string
+	var done = make(chan struct{})
+	go func() {
+		for pgn := range pgns {
+			result = append(result, strings.TrimSpace(pgn.Text))
+		}
+		close(done)
+	}()
+	var err = load(pgns)
+	close(pgns)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(result)
+	return result
+}
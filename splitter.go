@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pgn is raw text of one game and its location in source file.
+type Pgn struct {
+	Text   string
+	File   string
+	Index  int   // 1-based game number in file, 0 if unknown
+	Line   int   // 1-based line of game start, 0 if unknown
+	Offset int64 // byte offset of game start
+}
+
+func (p *Pgn) Location() string {
+	if p.Line != 0 {
+		return fmt.Sprintf("%v:%v", p.File, p.Line)
+	}
+	return fmt.Sprintf("%v@%v", p.File, p.Offset)
+}
+
+const (
+	splitStateNone = iota
+	splitStateTags
+	splitStateMovetext
+	splitStateTerminated
+)
+
+const utf8BOM = "\ufeff"
+
+// pgnSplitter splits stream of PGN lines into games.
+// New game starts at tag line outside of movetext comment,
+// so blank line between games is not required.
+type pgnSplitter struct {
+	file      string
+	state     int
+	inComment bool
+	sb        strings.Builder
+	game      Pgn
+	index     int
+}
+
+// Add appends line (line ending may be present) and returns previous game if the line starts new one.
+func (s *pgnSplitter) Add(line string, lineNumber int, offset int64) (Pgn, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	line = strings.TrimPrefix(line, utf8BOM)
+	var trimmed = strings.TrimSpace(line)
+
+	var result Pgn
+	var completed bool
+
+	if !s.inComment && isTagLine(trimmed) {
+		if s.state == splitStateMovetext || s.state == splitStateTerminated {
+			result, completed = s.Flush()
+		}
+		if s.state == splitStateNone {
+			s.start(lineNumber, offset)
+		}
+		s.state = splitStateTags
+		line = trimmed
+	} else if trimmed == "" {
+		if s.state == splitStateNone {
+			return result, false
+		}
+	} else if line[0] == '%' && (s.state == splitStateNone || s.state == splitStateTerminated) {
+		// escape line between games, e.g. reason of quarantined game
+		return result, false
+	} else {
+		if s.state == splitStateNone {
+			s.start(lineNumber, offset)
+		}
+		if s.state == splitStateTags || s.state == splitStateNone {
+			s.state = splitStateMovetext
+		}
+		s.inComment = scanCommentState(line, s.inComment)
+		if s.state == splitStateMovetext && !s.inComment && endsWithResult(trimmed) {
+			s.state = splitStateTerminated
+		}
+	}
+
+	s.sb.WriteString(line)
+	s.sb.WriteString("\n")
+	return result, completed
+}
+
+// Flush returns current incomplete game.
+func (s *pgnSplitter) Flush() (Pgn, bool) {
+	if s.state == splitStateNone {
+		return Pgn{}, false
+	}
+	var result = s.game
+	result.Text = s.sb.String()
+	s.sb = strings.Builder{}
+	s.state = splitStateNone
+	s.inComment = false
+	return result, true
+}
+
+func (s *pgnSplitter) start(lineNumber int, offset int64) {
+	s.index++
+	s.game = Pgn{
+		File:   s.file,
+		Line:   lineNumber,
+		Offset: offset,
+	}
+	if lineNumber != 0 {
+		s.game.Index = s.index
+	}
+}
+
+// isTagLine checks for tag pair like [Event "..."].
+func isTagLine(line string) bool {
+	if len(line) < 2 || line[0] != '[' {
+		return false
+	}
+	var c = line[1]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// scanCommentState returns whether brace comment is still open at the end of line.
+func scanCommentState(line string, inComment bool) bool {
+	for i := 0; i < len(line); i++ {
+		var c = line[i]
+		if inComment {
+			if c == '}' {
+				inComment = false
+			}
+		} else if c == '{' {
+			inComment = true
+		} else if c == ';' {
+			// rest of line comment
+			break
+		}
+	}
+	return inComment
+}
+
+func endsWithResult(line string) bool {
+	var index = strings.LastIndexAny(line, " \t")
+	var last = line[index+1:]
+	return last == GameResultWhiteWin || last == GameResultBlackWin ||
+		last == GameResultDraw || last == GameResultNone
+}
+
+// isGameBoundary checks line pair used to resynchronize on game start without context:
+// "[Event" tag after blank line or after line ending with game result.
+func isGameBoundary(prevLine, line string) bool {
+	line = strings.TrimPrefix(line, utf8BOM)
+	if !strings.HasPrefix(strings.TrimSpace(line), gameStartTag) {
+		return false
+	}
+	prevLine = strings.TrimSpace(prevLine)
+	return prevLine == "" || endsWithResult(prevLine)
+}
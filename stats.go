@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// Stats counts events of the run by name, e.g. adjudication reasons.
+// It is shared by analyzing threads. Nil Stats ignores events.
+type Stats struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func NewStats() *Stats {
+	return &Stats{counts: make(map[string]int64)}
+}
+
+func (s *Stats) Add(name string, n int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.counts[name] += n
+	s.mu.Unlock()
+}
+
+func (s *Stats) Counts() map[string]int64 {
+	var result = make(map[string]int64)
+	if s == nil {
+		return result
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, count := range s.counts {
+		result[name] = count
+	}
+	return result
+}
+
+func (s *Stats) Log() {
+	var counts = s.Counts()
+	var names = make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("%v: %v\n", name, counts[name])
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ChizhovVadim/CounterGo/common"
+)
+
+const (
+	TacticalCapture   = "capture"
+	TacticalPromotion = "promotion"
+	TacticalCheck     = "check"
+)
+
+var tacticalCategories = []string{TacticalCapture, TacticalPromotion, TacticalCheck}
+
+// tacticalMove returns tactical categories of SAN or LAN move in position.
+// Move which can not be played is not tactical.
+func tacticalMove(p *common.Position, castling Castling, move string) (capture, promotion, check bool) {
+	if move == "" {
+		return false, false, false
+	}
+	var child, _, ok = makeMoveSAN(p, castling, move)
+	if !ok {
+		child, ok = p.MakeMoveLAN(move)
+		if !ok {
+			return false, false, false
+		}
+	}
+	var own, childOwn = p.Black, child.Black
+	if p.WhiteMove {
+		own, childOwn = p.White, child.White
+	}
+	capture = common.PopCount(child.White|child.Black) < common.PopCount(p.White|p.Black)
+	promotion = common.PopCount(child.Pawns&childOwn) < common.PopCount(p.Pawns&own)
+	check = child.IsCheck()
+	return capture, promotion, check
+}
+
+// isTactical reports whether move belongs to one of categories.
+func isTactical(p *common.Position, castling Castling, move string, categories []string) bool {
+	var capture, promotion, check = tacticalMove(p, castling, move)
+	return capture && containsString(categories, TacticalCapture) ||
+		promotion && containsString(categories, TacticalPromotion) ||
+		check && containsString(categories, TacticalCheck)
+}
+
+func validateTacticalCategories(categories []string) error {
+	for _, category := range categories {
+		if !containsString(tacticalCategories, category) {
+			return fmt.Errorf("unknown tactical move category %v, expected one of %v",
+				category, strings.Join(tacticalCategories, ", "))
+		}
+	}
+	return nil
+}
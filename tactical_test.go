@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTacticalMove(t *testing.T) {
+	for _, test := range []struct {
+		fen                       string
+		move                      string
+		capture, promotion, check bool
+	}{
+		{"rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2", "dxe5", true, false, false},
+		{"rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2", "d4e5", true, false, false},
+		{"rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2", "Nf3", false, false, false},
+		{"rnbqkbnr/ppp2ppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 3", "exd6", true, false, false},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b8=Q+", false, true, true},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b8=N", false, true, false},
+		{"r3k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "bxa8=Q+", true, true, true},
+		{"4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", "O-O-O", false, false, false},
+		{"3k4/8/8/8/8/8/8/R3K3 w Q - 0 1", "O-O-O+", false, false, true},
+		{"4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", "Ra9", false, false, false},
+		{"4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", "", false, false, false},
+	} {
+		var p, castling, err = parseAnyFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var capture, promotion, check = tacticalMove(&p, castling, test.move)
+		if capture != test.capture || promotion != test.promotion || check != test.check {
+			t.Errorf("%v %v: got %v %v %v", test.fen, test.move, capture, promotion, check)
+		}
+	}
+
+	var settings = &AnalyzeSettings{Filters: FilterSettings{
+		TacticalPlayed: []string{TacticalCapture},
+		TacticalPV:     []string{TacticalCheck},
+	}}
+	var stats = NewStats()
+	var positions, err = AnalyzeGame(&AllQuietService{}, settings, nil,
+		"[Result \"1-0\"]\n\n1. e4 {+0.30/20} d5 {(d5) -0.30/20} 2. exd5 {(exd5) +0.30/20} Qxd5 {(Qxd5) -0.30/20} "+
+			"3. Nc3 {(Nc3) +0.30/20} Qa5 {(Qe5+) -0.30/20} 1-0", stats)
+	var counts = stats.Counts()
+	if err != nil || len(positions) != 3 ||
+		counts[filterStatsPrefix+"played move"] != 2 || counts[filterStatsPrefix+"pv move"] != 1 {
+		t.Errorf("got %v %v %v", len(positions), err, counts)
+	}
+	if validateTacticalCategories([]string{"capture", "sacrifice"}) == nil {
+		t.Error("unknown category accepted")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseTagSection parses tag pairs at the beginning of game text according to PGN standard:
+// [Symbol "String"], where string may contain \" and \\ escapes and brackets.
+// It returns tags in source order, the rest of text as movetext and line where movetext starts.
+// Error is *GameError with line of the bad tag.
+func parseTagSection(pgn string) (tags []Tag, movetext string, movetextLine int, err error) {
+	tags = make([]Tag, 0, 16)
+	var line = 1
+	var pos = 0
+	for {
+		// skip white space and escape lines between tags
+		for pos < len(pgn) {
+			var c = pgn[pos]
+			if c == '\n' {
+				line++
+				pos++
+			} else if c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f' {
+				pos++
+			} else if c == '%' && (pos == 0 || pgn[pos-1] == '\n') {
+				for pos < len(pgn) && pgn[pos] != '\n' {
+					pos++
+				}
+			} else if strings.HasPrefix(pgn[pos:], utf8BOM) {
+				pos += len(utf8BOM)
+			} else {
+				break
+			}
+		}
+		if pos >= len(pgn) || pgn[pos] != '[' {
+			return tags, pgn[pos:], line, nil
+		}
+		var tag Tag
+		var end int
+		tag, end, err = parseTagPair(pgn, pos)
+		if err != nil {
+			return nil, "", 0, newGameError(ReasonBadTags, line, "%v", err)
+		}
+		line += strings.Count(pgn[pos:end], "\n")
+		pos = end
+		tags = append(tags, tag)
+	}
+}
+
+// parseTagPair parses tag pair starting at '[' and returns position after closing ']'.
+func parseTagPair(pgn string, pos int) (Tag, int, error) {
+	pos++
+	pos = skipSpaces(pgn, pos)
+	var start = pos
+	for pos < len(pgn) && isSymbolContinuation(pgn[pos]) {
+		pos++
+	}
+	if pos == start {
+		return Tag{}, 0, fmt.Errorf("bad tag name")
+	}
+	var key = pgn[start:pos]
+	pos = skipSpaces(pgn, pos)
+	if pos >= len(pgn) || pgn[pos] != '"' {
+		return Tag{}, 0, fmt.Errorf("tag %v: value expected", key)
+	}
+	pos++
+
+	var sb strings.Builder
+	for {
+		if pos >= len(pgn) || pgn[pos] == '\n' {
+			return Tag{}, 0, fmt.Errorf("tag %v: unterminated value", key)
+		}
+		var c = pgn[pos]
+		if c == '\\' && pos+1 < len(pgn) && (pgn[pos+1] == '"' || pgn[pos+1] == '\\') {
+			sb.WriteByte(pgn[pos+1])
+			pos += 2
+			continue
+		}
+		if c == '"' {
+			// unescaped quote inside value is tolerated unless it is followed by ']'
+			var next = skipSpaces(pgn, pos+1)
+			if next < len(pgn) && pgn[next] == ']' {
+				return Tag{Key: key, Value: sb.String()}, next + 1, nil
+			}
+		}
+		sb.WriteByte(c)
+		pos++
+	}
+}
+
+func skipSpaces(s string, pos int) int {
+	for pos < len(s) && (s[pos] == ' ' || s[pos] == '\t' || s[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
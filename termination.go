@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TerminationNormal     = "normal"
+	TerminationTime       = "time"
+	TerminationIllegal    = "illegal"
+	TerminationCrash      = "crash"
+	TerminationDisconnect = "disconnect"
+)
+
+const terminationStatsPrefix = "termination: "
+
+var terminationClasses = []string{TerminationNormal, TerminationTime, TerminationIllegal, TerminationCrash, TerminationDisconnect}
+
+const (
+	TerminationKeep       = "keep"
+	TerminationDrop       = "drop"
+	TerminationAdjudicate = "adjudicate"
+)
+
+// terminationPatterns are lower case substrings of Termination tag (PGN standard, cutechess, fastchess, lichess)
+// and of final comments written by cutechess and fastchess, e.g. {White loses on time}.
+var terminationPatterns = []struct {
+	class    string
+	patterns []string
+}{
+	{TerminationTime, []string{"time forfeit", "loses on time", "forfeits on time", "timeout"}},
+	{TerminationIllegal, []string{"illegal move", "rules infraction"}},
+	{TerminationCrash, []string{"terminates abnormally", "crash", "death", "emergency"}},
+	{TerminationDisconnect, []string{"disconnect", "stall", "abandon"}},
+}
+
+// classifyTermination classifies game termination by Termination tag
+// and comments after the last move of the main line.
+func classifyTermination(game *Game) string {
+	if termination, found := game.TagValue("Termination"); found {
+		if class := matchTermination(termination); class != TerminationNormal {
+			return class
+		}
+	}
+	if len(game.Items) != 0 {
+		return matchTermination(game.Items[len(game.Items)-1].TxtComment)
+	}
+	return TerminationNormal
+}
+
+func matchTermination(s string) string {
+	s = strings.ToLower(s)
+	for _, item := range terminationPatterns {
+		for _, pattern := range item.patterns {
+			if strings.Contains(s, pattern) {
+				return item.class
+			}
+		}
+	}
+	return TerminationNormal
+}
+
+// terminationAction returns action for termination class by rules "class=action", keep by default.
+func (settings *AnalyzeSettings) terminationAction(class string) string {
+	for _, rule := range settings.Terminations {
+		if index := strings.IndexByte(rule, '='); index >= 0 && rule[:index] == class {
+			return rule[index+1:]
+		}
+	}
+	return TerminationKeep
+}
+
+func validateTerminationRules(rules []string) error {
+	for _, rule := range rules {
+		var index = strings.IndexByte(rule, '=')
+		if index < 0 {
+			return fmt.Errorf("bad termination rule %v, expected class=action", rule)
+		}
+		var class, action = rule[:index], rule[index+1:]
+		if !containsString(terminationClasses, class) {
+			return fmt.Errorf("unknown termination class %v, expected one of %v",
+				class, strings.Join(terminationClasses, ", "))
+		}
+		switch action {
+		case TerminationKeep, TerminationDrop, TerminationAdjudicate:
+		default:
+			return fmt.Errorf("bad termination action %v", action)
+		}
+	}
+	return nil
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTermination(t *testing.T) {
+	for _, test := range []struct {
+		pgn      string
+		expected string
+	}{
+		{"[Result \"1-0\"]\n\n1. e4 {+0.30/20 1s} e5 {-0.20/20 1s} {Black loses on time} 1-0", TerminationTime},
+		{"[Result \"1-0\"]\n[Termination \"time forfeit\"]\n\n1. e4 e5 1-0", TerminationTime},
+		{"[Result \"0-1\"]\n\n1. e4 e5 {White makes an illegal move: Nf9} 0-1", TerminationIllegal},
+		{"[Result \"0-1\"]\n[Termination \"rules infraction\"]\n\n1. e4 e5 0-1", TerminationIllegal},
+		{"[Result \"1-0\"]\n\n1. e4 e5 {Black terminates abnormally} 1-0", TerminationCrash},
+		{"[Result \"1-0\"]\n\n1. e4 e5 {Black's connection stalls} 1-0", TerminationDisconnect},
+		{"[Result \"1-0\"]\n[Termination \"abandoned\"]\n\n1. e4 e5 1-0", TerminationDisconnect},
+		{"[Result \"1-0\"]\n[Termination \"adjudication\"]\n\n1. e4 e5 {White wins by adjudication} 1-0", TerminationNormal},
+	} {
+		var game, err = ParseGame(test.pgn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if class := classifyTermination(&game); class != test.expected {
+			t.Errorf("%q: got %v", test.pgn, class)
+		}
+	}
+
+	// eval of the last move is kept after termination comment
+	const pgn = "[Result \"0-1\"]\n\n1. e4 {+0.05/20} e5 {-0.05/20} 2. Nf3 {0.00/20} Nc6 {+0.10/20} {White loses on time} 0-1"
+	for _, test := range []struct {
+		rule       string
+		positions  int
+		gameResult float32
+		stat       string
+	}{
+		{"time=keep", 4, 0, "termination: time kept"},
+		{"time=drop", 0, 0, "termination: time dropped"},
+		{"time=adjudicate", 4, 0.5, "termination: time adjudicated"},
+		{"illegal=drop", 4, 0, "termination: time kept"},
+	} {
+		var settings = &AnalyzeSettings{Terminations: []string{test.rule}, AdjudicateDrawScore: 10, AdjudicateDrawMoves: 2}
+		var stats = NewStats()
+		var positions, err = AnalyzeGame(&AllQuietService{}, settings, nil, pgn, stats)
+		if err != nil || len(positions) != test.positions ||
+			len(positions) != 0 && positions[0].gameResult != test.gameResult ||
+			stats.Counts()[test.stat] != 1 {
+			t.Errorf("%v: got %v %v %v", test.rule, positions, err, stats.Counts())
+		}
+	}
+
+	if validateTerminationRules([]string{"time=skip"}) == nil || validateTerminationRules([]string{"lag=drop"}) == nil {
+		t.Error("bad rule accepted")
+	}
+}